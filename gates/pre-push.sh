@@ -17,6 +17,9 @@ else
   cd worker && npm run build 2>&1 | tail -1 && cd ..
 fi
 
+echo "  openapi route table..."
+node worker/check-openapi.mjs
+
 # MCP smoke test — start dev server, verify tools, kill
 echo "  mcp smoke test..."
 MCP_PORT=4399
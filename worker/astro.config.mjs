@@ -2,11 +2,30 @@
 import { defineConfig } from 'astro/config';
 import cloudflare from '@astrojs/cloudflare';
 import tailwindcss from '@tailwindcss/vite';
+import { execSync } from 'node:child_process';
+import { readFileSync } from 'node:fs';
+
+const pkg = JSON.parse(readFileSync(new URL('./package.json', import.meta.url), 'utf8'));
+
+// Commit for /api/version: CI's GITHUB_SHA, else the local checkout, else unknown
+function commit() {
+  if (process.env.GITHUB_SHA) return process.env.GITHUB_SHA.slice(0, 12);
+  try {
+    return execSync('git rev-parse --short=12 HEAD', { stdio: ['ignore', 'pipe', 'ignore'] }).toString().trim();
+  } catch {
+    return 'unknown';
+  }
+}
 
 export default defineConfig({
   output: 'server',
   adapter: cloudflare(),
   vite: {
-    plugins: [tailwindcss()]
+    plugins: [tailwindcss()],
+    define: {
+      __CHOMP_VERSION__: JSON.stringify(pkg.version),
+      __CHOMP_COMMIT__: JSON.stringify(commit()),
+      __CHOMP_BUILT__: JSON.stringify(new Date().toISOString()),
+    },
   }
 });
@@ -0,0 +1,62 @@
+#!/usr/bin/env node
+/**
+ * OpenAPI route check — compares the `endpoints` table in src/lib/openapi.ts with the
+ * route files under src/pages, so a route added without a table entry (or an entry left
+ * behind after a route is removed) fails the gate.
+ * Usage: node check-openapi.mjs
+ *
+ * Exit 0 = in sync, exit 1 = drift
+ */
+import { readFileSync, readdirSync } from "node:fs"
+import { join, relative } from "node:path"
+
+const ROOT = new URL(".", import.meta.url).pathname
+const PAGES = join(ROOT, "src/pages")
+const METHODS = ["GET", "POST", "PUT", "DELETE"]
+
+/** Routes that are deliberately not part of the OpenAPI description. */
+const UNDOCUMENTED = new Set([
+  // MCP transport, described by the MCP handshake itself
+  "GET /mcp",
+  "POST /mcp",
+  "DELETE /mcp",
+  // OG image for the docs site
+  "GET /api/og",
+])
+
+function walk(dir) {
+  return readdirSync(dir, { withFileTypes: true }).flatMap((e) =>
+    e.isDirectory() ? walk(join(dir, e.name)) : e.name.endsWith(".ts") ? [join(dir, e.name)] : []
+  )
+}
+
+/** src/pages/api/result/[id].ts → /api/result/{id}; [...path] → {path} */
+function routePath(file) {
+  const path = "/" + relative(PAGES, file).replace(/\.ts$/, "").replace(/(^|\/)index$/, "")
+  return path.replace(/\[(?:\.\.\.)?(\w+)\]/g, "{$1}")
+}
+
+const routes = new Set()
+for (const file of walk(PAGES)) {
+  const source = readFileSync(file, "utf8")
+  for (const method of METHODS) {
+    if (new RegExp(`export const ${method}\\b`).test(source)) routes.add(`${method} ${routePath(file)}`)
+  }
+}
+
+const table = readFileSync(join(ROOT, "src/lib/openapi.ts"), "utf8")
+const documented = new Set(
+  [...table.matchAll(/method: '(\w+)', path: '([^']+)'/g)].map(([, method, path]) => `${method.toUpperCase()} ${path}`)
+)
+
+const missing = [...routes].filter((r) => !documented.has(r) && !UNDOCUMENTED.has(r)).sort()
+const stale = [...documented].filter((r) => !routes.has(r)).sort()
+
+for (const r of missing) console.log(`  missing from openapi.ts endpoints: ${r}`)
+for (const r of stale) console.log(`  openapi.ts entry has no route: ${r}`)
+
+if (missing.length || stale.length) {
+  console.log("[chomp] ✗ openapi route table out of sync")
+  process.exit(1)
+}
+console.log(`  openapi route table: ${documented.size} entries match src/pages`)
@@ -6,8 +6,20 @@ type Fetcher = import('@cloudflare/workers-types').Fetcher
 interface Env {
   JOBS: KVNamespace
   ASSETS: Fetcher
+  CORS_ORIGINS?: string
+  ADMIN_TOKEN?: string
+  MAINTENANCE_MODE?: string
+  ALLOW_PROVIDER_KEYS?: string
+  QUEUE_MAX_WAIT?: string
+  MAX_INFLIGHT?: string
+  UPDATE_CHECK?: string
 }
 
+// Build info, injected by vite `define` in astro.config.mjs
+declare const __CHOMP_VERSION__: string
+declare const __CHOMP_COMMIT__: string
+declare const __CHOMP_BUILT__: string
+
 type Runtime = import('@astrojs/cloudflare').Runtime<Env>
 
 declare namespace App {
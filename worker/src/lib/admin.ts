@@ -0,0 +1,85 @@
+/**
+ * Admin: instance-wide controls, authenticated with the ADMIN_TOKEN secret
+ * (`wrangler secret put ADMIN_TOKEN`). Admin endpoints are disabled when it's unset.
+ */
+
+import { extractToken, jsonResponse } from './auth'
+
+function timingSafeEqual(a: string, b: string): boolean {
+  if (a.length !== b.length) return false
+  let diff = 0
+  for (let i = 0; i < a.length; i++) diff |= a.charCodeAt(i) ^ b.charCodeAt(i)
+  return diff === 0
+}
+
+/** Returns an error response unless the request carries the admin token. */
+export function requireAdmin(request: Request, env: Env): Response | null {
+  if (!env.ADMIN_TOKEN) return jsonResponse({ error: 'admin endpoints disabled (ADMIN_TOKEN not set)' }, 404)
+  const token = extractToken(request)
+  if (!token || !timingSafeEqual(token, env.ADMIN_TOKEN)) return jsonResponse({ error: 'forbidden' }, 403)
+  return null
+}
+
+// ---------------------------------------------------------------------------
+// Maintenance (read-only) mode
+// ---------------------------------------------------------------------------
+
+export interface Mode {
+  mode: 'normal' | 'read-only'
+  message: string
+  since: string
+  source: 'env' | 'kv' | 'default'
+}
+
+const MODE_KEY = 'config:mode'
+const MODE_CACHE_MS = 10_000
+let cachedMode: { value: Mode; at: number } | null = null
+
+/** Current mode. MAINTENANCE_MODE in the environment wins over the KV setting. */
+export async function getMode(env: Env): Promise<Mode> {
+  if (env.MAINTENANCE_MODE && env.MAINTENANCE_MODE !== 'false' && env.MAINTENANCE_MODE !== '0') {
+    return { mode: 'read-only', message: env.MAINTENANCE_MODE, since: '', source: 'env' }
+  }
+  if (cachedMode && Date.now() - cachedMode.at < MODE_CACHE_MS) return cachedMode.value
+
+  const raw = await env.JOBS.get(MODE_KEY)
+  const value: Mode = raw
+    ? { ...(JSON.parse(raw) as Omit<Mode, 'source'>), source: 'kv' }
+    : { mode: 'normal', message: '', since: '', source: 'default' }
+  cachedMode = { value, at: Date.now() }
+  return value
+}
+
+export async function setMode(env: Env, mode: Mode['mode'], message: string): Promise<Mode> {
+  const value = { mode, message, since: new Date().toISOString() }
+  if (mode === 'normal') await env.JOBS.delete(MODE_KEY)
+  else await env.JOBS.put(MODE_KEY, JSON.stringify(value))
+  cachedMode = null
+  return getMode(env)
+}
+
+/** Methods that never change state and keep working in read-only mode. */
+export function isReadOnlyMethod(method: string): boolean {
+  return method === 'GET' || method === 'HEAD' || method === 'OPTIONS'
+}
+
+/** MCP tools that only read; calls to them keep working in read-only mode. */
+const READ_ONLY_MCP_TOOLS = new Set(['result'])
+
+/**
+ * Whether a POST /mcp body (one JSON-RPC message or a batch) changes no state: protocol
+ * messages such as `initialize` and `tools/list`, and calls to read-only tools.
+ */
+export async function isReadOnlyMcpRequest(request: Request): Promise<boolean> {
+  let body: unknown
+  try {
+    body = await request.clone().json()
+  } catch {
+    return false
+  }
+  const messages = Array.isArray(body) ? body : [body]
+  return messages.every(m => {
+    const msg = m as { method?: unknown; params?: { name?: unknown } } | null
+    return msg?.method !== 'tools/call' || READ_ONLY_MCP_TOOLS.has(String(msg.params?.name))
+  })
+}
@@ -8,9 +8,17 @@
 
 export interface UserRecord {
   keys: Record<string, string> // routerId → apiKey, e.g. { "openrouter": "sk-or-...", "groq": "gsk_..." }
+  settings?: Record<string, Record<string, string>> // routerId → extra values, e.g. { "cloudflare": { "account_id": "..." } }
   created: string
 }
 
+/** A new chomp token: 32 random bytes as hex. */
+export function generateToken(): string {
+  const bytes = new Uint8Array(32)
+  crypto.getRandomValues(bytes)
+  return Array.from(bytes).map(b => b.toString(16).padStart(2, '0')).join('')
+}
+
 export function extractToken(request: Request): string | null {
   const header = request.headers.get('Authorization') || ''
   if (!header.startsWith('Bearer ')) return null
@@ -30,11 +38,26 @@ export async function resolveUser(token: string, kv: KVNamespace): Promise<UserR
   return parsed as UserRecord
 }
 
+export async function saveUser(token: string, user: UserRecord, kv: KVNamespace): Promise<void> {
+  await kv.put(`user:${token}`, JSON.stringify(user))
+}
+
 /** Return the user's API key for the given router, or null if they don't have one. */
 export function getUserKey(user: UserRecord, routerId: string): string | null {
   return user.keys[routerId] ?? null
 }
 
+/** Return the user's extra settings for the given router (empty if none). */
+export function getUserSettings(user: UserRecord, routerId: string): Record<string, string> {
+  return user.settings?.[routerId] ?? {}
+}
+
+/** Short, safe-to-display form of an API key, e.g. "sk-o...f3a9". */
+export function previewKey(key: string): string {
+  if (key.length <= 8) return key.slice(0, 2) + '...' + key.slice(-2)
+  return key.slice(0, 4) + '...' + key.slice(-4)
+}
+
 /** Return the first router ID (from the ordered list) that the user has a key for, or null. */
 export function getFirstAvailableRouter(user: UserRecord, routerIds: string[]): string | null {
   for (const id of routerIds) {
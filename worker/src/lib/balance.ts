@@ -0,0 +1,114 @@
+/**
+ * Load balancing: how "auto" picks a router when none is named. Stored per user in KV as
+ * `balance:{token}`.
+ *
+ * - `priority` (default): the fixed order of the `routers` array
+ * - `weighted`: random pick proportional to `weights` (routers without a weight get 1, 0 disables)
+ * - `lru`: least recently used first, tracked in `balance:lastused:{token}`
+ * - `fastest`: interactive /v1 traffic goes to the lowest rolling p50 latency (see latency.ts);
+ *   background jobs go to the routers with the most remaining request quota, slowest first,
+ *   leaving the fast ones free for interactive calls. Routers out of quota sort last either way.
+ *
+ * The result is a preference order; resolution still falls through to later routers.
+ */
+
+import { z } from 'zod'
+import type { UserRecord } from './auth'
+import { routers, missingSettings } from './routers'
+import { getLatencySamples, latencyByRouter } from './latency'
+import { getRateLimits } from './ratelimit'
+
+export const BalanceSchema = z.object({
+  strategy: z.enum(['priority', 'weighted', 'lru', 'fastest']).default('priority'),
+  weights: z.record(z.number().nonnegative()).default({}),
+}).strict()
+
+export type Balance = z.infer<typeof BalanceSchema>
+
+export const defaultBalance: Balance = { strategy: 'priority', weights: {} }
+
+export async function getBalance(kv: KVNamespace, token: string): Promise<Balance> {
+  const raw = await kv.get(`balance:${token}`)
+  return raw ? { ...defaultBalance, ...JSON.parse(raw) } : defaultBalance
+}
+
+export async function saveBalance(kv: KVNamespace, token: string, balance: Balance): Promise<void> {
+  await kv.put(`balance:${token}`, JSON.stringify(balance))
+}
+
+export async function deleteBalance(kv: KVNamespace, token: string): Promise<void> {
+  await kv.delete(`balance:${token}`)
+}
+
+function configured(user: UserRecord): string[] {
+  return routers
+    .filter(r => user.keys[r.id] && missingSettings(r, user.settings?.[r.id]).length === 0)
+    .map(r => r.id)
+}
+
+/** Weighted shuffle: repeatedly draw without replacement, proportional to weight. */
+function weightedOrder(ids: string[], weights: Record<string, number>): string[] {
+  const pool = ids.map(id => ({ id, w: weights[id] ?? 1 })).filter(e => e.w > 0)
+  const out: string[] = []
+  while (pool.length > 0) {
+    let r = Math.random() * pool.reduce((sum, e) => sum + e.w, 0)
+    const i = pool.findIndex(e => (r -= e.w) < 0)
+    out.push(pool.splice(i === -1 ? pool.length - 1 : i, 1)[0].id)
+  }
+  return out
+}
+
+export type Traffic = 'interactive' | 'background'
+
+/** Order for the `fastest` strategy. Routers without samples or quota info keep priority order. */
+async function fastestOrder(kv: KVNamespace, token: string, ids: string[], traffic: Traffic): Promise<string[]> {
+  const [latency, limits] = await Promise.all([
+    getLatencySamples(kv, token).then(latencyByRouter),
+    getRateLimits(kv, token),
+  ])
+  const p50 = (id: string) => latency[id]?.p50 ?? Infinity
+  const remaining = (id: string) => limits[id]?.remaining_requests ?? null
+  const exhausted = (id: string) => remaining(id) === 0 ? 1 : 0
+
+  return [...ids].sort((a, b) => {
+    if (exhausted(a) !== exhausted(b)) return exhausted(a) - exhausted(b)
+    if (traffic === 'interactive') return p50(a) - p50(b) || 0
+    const quota = (remaining(b) ?? -1) - (remaining(a) ?? -1)
+    if (quota !== 0) return quota
+    const pa = latency[a]?.p50 ?? -1
+    const pb = latency[b]?.p50 ?? -1
+    return pb - pa
+  })
+}
+
+/**
+ * The user's configured routers in preference order for this request, or undefined for
+ * the default priority order. `traffic` only matters to the `fastest` strategy.
+ */
+export async function routerPreference(
+  kv: KVNamespace,
+  token: string,
+  user: UserRecord,
+  traffic: Traffic = 'interactive',
+): Promise<string[] | undefined> {
+  const balance = await getBalance(kv, token)
+  if (balance.strategy === 'priority') return undefined
+
+  const ids = configured(user)
+  if (balance.strategy === 'weighted') return weightedOrder(ids, balance.weights)
+  if (balance.strategy === 'fastest') return fastestOrder(kv, token, ids, traffic)
+
+  const raw = await kv.get(`balance:lastused:${token}`)
+  const lastUsed: Record<string, number> = raw ? JSON.parse(raw) : {}
+  return [...ids].sort((a, b) => (lastUsed[a] ?? 0) - (lastUsed[b] ?? 0))
+}
+
+/** Note that a router was just used (only tracked under the `lru` strategy). */
+export async function markRouterUsed(kv: KVNamespace, token: string, routerId: string): Promise<void> {
+  const balance = await getBalance(kv, token)
+  if (balance.strategy !== 'lru') return
+  const raw = await kv.get(`balance:lastused:${token}`)
+  const lastUsed: Record<string, number> = raw ? JSON.parse(raw) : {}
+  lastUsed[routerId] = Date.now()
+  await kv.put(`balance:lastused:${token}`, JSON.stringify(lastUsed))
+}
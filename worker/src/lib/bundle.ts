@@ -0,0 +1,187 @@
+/**
+ * Config bundles: every per-user setting in one JSON document, for moving a setup to a new
+ * token or rebuilding it. Router keys are left out unless asked for, and can be encrypted
+ * with a passphrase (AES-GCM, key from PBKDF2-SHA256) so the bundle is safe to store.
+ *
+ * Import validates every section before writing any of them; sections that are absent are
+ * left untouched.
+ */
+
+import { z } from 'zod'
+import type { UserRecord } from './auth'
+import { saveUser } from './auth'
+import { getRouter } from './routers'
+import { routerSettingsSchema, applyRouterSettings, formatIssues } from './settings'
+import { ModelPolicySchema, getModelPolicy, saveModelPolicy } from './policy'
+import { BalanceSchema, getBalance, saveBalance } from './balance'
+import { RedactionSchema, getRedaction, saveRedaction } from './redact'
+import { NotificationsSchema, getNotifications, saveNotifications } from './notify'
+import { GuardrailsSchema, getGuardrails, saveGuardrails } from './guardrails'
+import { TransformsSchema, getTransforms, saveTransforms } from './transforms'
+import { PresetsSchema, PinnedSchema, getPresets, replacePresets, getPinnedPrompts, savePinnedPrompts } from './prompts'
+
+export const BUNDLE_VERSION = 1
+const PBKDF2_ITERATIONS = 100_000
+
+const EncryptedSchema = z.object({
+  alg: z.literal('AES-GCM'),
+  kdf: z.literal('PBKDF2-SHA256'),
+  iterations: z.number().int().positive().max(1_000_000),
+  salt: z.string(),
+  iv: z.string(),
+  data: z.string(),
+}).strict()
+
+type Encrypted = z.infer<typeof EncryptedSchema>
+
+export const BundleSchema = z.object({
+  chomp_bundle: z.literal(BUNDLE_VERSION),
+  exported: z.string().optional(),
+  models: ModelPolicySchema.optional(),
+  balancing: BalanceSchema.optional(),
+  redaction: RedactionSchema.optional(),
+  notifications: NotificationsSchema.optional(),
+  guardrails: GuardrailsSchema.optional(),
+  transforms: TransformsSchema.optional(),
+  prompts: PresetsSchema.optional(),
+  pinned: PinnedSchema.optional(),
+  router_settings: z.record(z.record(z.string())).optional(),
+  keys: z.record(z.string()).optional(),
+  encrypted_keys: EncryptedSchema.optional(),
+}).strict()
+
+export type Bundle = z.infer<typeof BundleSchema>
+
+const b64 = (bytes: Uint8Array) => btoa(String.fromCharCode(...bytes))
+const unb64 = (text: string) => Uint8Array.from(atob(text), c => c.charCodeAt(0))
+
+async function deriveKey(passphrase: string, salt: Uint8Array, iterations: number): Promise<CryptoKey> {
+  const material = await crypto.subtle.importKey('raw', new TextEncoder().encode(passphrase), 'PBKDF2', false, ['deriveKey'])
+  return crypto.subtle.deriveKey(
+    { name: 'PBKDF2', hash: 'SHA-256', salt, iterations },
+    material,
+    { name: 'AES-GCM', length: 256 },
+    false,
+    ['encrypt', 'decrypt'],
+  )
+}
+
+async function encryptKeys(keys: Record<string, string>, passphrase: string): Promise<Encrypted> {
+  const salt = crypto.getRandomValues(new Uint8Array(16))
+  const iv = crypto.getRandomValues(new Uint8Array(12))
+  const key = await deriveKey(passphrase, salt, PBKDF2_ITERATIONS)
+  const data = await crypto.subtle.encrypt({ name: 'AES-GCM', iv }, key, new TextEncoder().encode(JSON.stringify(keys)))
+  return { alg: 'AES-GCM', kdf: 'PBKDF2-SHA256', iterations: PBKDF2_ITERATIONS, salt: b64(salt), iv: b64(iv), data: b64(new Uint8Array(data)) }
+}
+
+async function decryptKeys(enc: Encrypted, passphrase: string): Promise<Record<string, string> | null> {
+  try {
+    const key = await deriveKey(passphrase, unb64(enc.salt), enc.iterations)
+    const data = await crypto.subtle.decrypt({ name: 'AES-GCM', iv: unb64(enc.iv) }, key, unb64(enc.data))
+    return JSON.parse(new TextDecoder().decode(data))
+  } catch {
+    return null
+  }
+}
+
+export async function exportBundle(
+  kv: KVNamespace,
+  token: string,
+  user: UserRecord,
+  opts: { includeKeys: boolean; passphrase?: string },
+): Promise<Bundle> {
+  const [models, balancing, redaction, notifications, guardrails, transforms, prompts, pinned] = await Promise.all([
+    getModelPolicy(kv, token),
+    getBalance(kv, token),
+    getRedaction(kv, token),
+    getNotifications(kv, token),
+    getGuardrails(kv, token),
+    getTransforms(kv, token),
+    getPresets(kv, token),
+    getPinnedPrompts(kv, token),
+  ])
+  const bundle: Bundle = {
+    chomp_bundle: BUNDLE_VERSION,
+    exported: new Date().toISOString(),
+    models,
+    balancing,
+    redaction,
+    notifications,
+    guardrails,
+    transforms,
+    prompts,
+    pinned,
+    router_settings: user.settings ?? {},
+  }
+  if (opts.includeKeys) {
+    if (opts.passphrase) bundle.encrypted_keys = await encryptKeys(user.keys, opts.passphrase)
+    else bundle.keys = user.keys
+  }
+  return bundle
+}
+
+/**
+ * Apply a bundle. Returns the sections written and the resulting user record, or an error
+ * (nothing is written then).
+ * Router keys replace the user's key and settings for each router named; other routers are kept.
+ * Router settings without a key in the bundle apply to routers the user already has a key for.
+ */
+export async function importBundle(
+  kv: KVNamespace,
+  token: string,
+  user: UserRecord,
+  raw: unknown,
+  passphrase?: string,
+): Promise<{ imported: string[]; user: UserRecord } | { error: string }> {
+  const parsed = BundleSchema.safeParse(raw)
+  if (!parsed.success) return { error: formatIssues(parsed.error) }
+  const bundle = parsed.data
+
+  let keys = bundle.keys
+  if (bundle.encrypted_keys) {
+    if (!passphrase) return { error: 'encrypted_keys needs the X-Bundle-Passphrase header' }
+    const decrypted = await decryptKeys(bundle.encrypted_keys, passphrase)
+    if (!decrypted) return { error: 'could not decrypt encrypted_keys (wrong passphrase?)' }
+    keys = decrypted
+  }
+
+  // Check every router before touching the user record
+  const updated: UserRecord = { ...user, keys: { ...user.keys }, settings: { ...(user.settings ?? {}) } }
+  for (const [routerId, key] of Object.entries(keys ?? {})) {
+    const router = getRouter(routerId)
+    if (!router) return { error: `keys: unknown router ${routerId}` }
+    const input = routerSettingsSchema(router).safeParse({ key, settings: bundle.router_settings?.[routerId] ?? {} })
+    if (!input.success) return { error: `${routerId}: ${formatIssues(input.error)}` }
+    applyRouterSettings(updated, routerId, input.data)
+  }
+  const settingsOnly = Object.entries(bundle.router_settings ?? {}).filter(([routerId]) => !keys?.[routerId])
+  for (const [routerId, settings] of settingsOnly) {
+    const router = getRouter(routerId)
+    if (!router) return { error: `router_settings: unknown router ${routerId}` }
+    const key = user.keys[routerId]
+    if (!key) return { error: `router_settings: no key for ${routerId}; add one or include it in keys` }
+    const input = routerSettingsSchema(router).safeParse({ key, settings })
+    if (!input.success) return { error: `${routerId}: ${formatIssues(input.error)}` }
+    applyRouterSettings(updated, routerId, input.data)
+  }
+
+  const writes: Array<[string, Promise<void>]> = []
+  if (bundle.models) writes.push(['models', saveModelPolicy(kv, token, bundle.models)])
+  if (bundle.balancing) writes.push(['balancing', saveBalance(kv, token, bundle.balancing)])
+  if (bundle.redaction) writes.push(['redaction', saveRedaction(kv, token, bundle.redaction)])
+  if (bundle.notifications) writes.push(['notifications', saveNotifications(kv, token, bundle.notifications)])
+  if (bundle.guardrails) writes.push(['guardrails', saveGuardrails(kv, token, bundle.guardrails)])
+  if (bundle.transforms) writes.push(['transforms', saveTransforms(kv, token, bundle.transforms)])
+  if (bundle.prompts) writes.push(['prompts', replacePresets(kv, token, bundle.prompts)])
+  if (bundle.pinned) writes.push(['pinned', savePinnedPrompts(kv, token, bundle.pinned)])
+  const userSections = [
+    ...(keys && Object.keys(keys).length ? ['keys'] : []),
+    ...(settingsOnly.length ? ['router_settings'] : []),
+  ]
+  if (userSections.length) {
+    const saved = saveUser(token, updated, kv)
+    for (const name of userSections) writes.push([name, saved])
+  }
+  await Promise.all(writes.map(([, p]) => p))
+  return { imported: writes.map(([name]) => name), user: updated }
+}
@@ -0,0 +1,97 @@
+/**
+ * Job callbacks: when a dispatch sets `callback_url`, the finished job is POSTed there.
+ * Each user has a signing secret in KV as `callbacksecret:{token}`, created on first use.
+ * Requests carry `X-Chomp-Timestamp` and `X-Chomp-Signature: sha256=<hex>`, an HMAC-SHA256
+ * of `{timestamp}.{body}`, so receivers can verify the sender and reject replays.
+ */
+
+import { backoffMs } from './errors'
+
+const MAX_ATTEMPTS = 3
+const CALLBACK_TIMEOUT_MS = 10000
+
+export interface CallbackResult {
+  url: string
+  delivered: boolean
+  attempts: number
+  status?: number
+  error?: string
+}
+
+/** Validate a caller-supplied callback URL: https only, no credentials in it. */
+export function parseCallbackUrl(value: unknown): { url: string } | { error: string } {
+  if (typeof value !== 'string') return { error: 'callback_url must be a string' }
+  let url: URL
+  try {
+    url = new URL(value)
+  } catch {
+    return { error: 'callback_url must be a valid URL' }
+  }
+  if (url.protocol !== 'https:') return { error: 'callback_url must be https' }
+  if (url.username || url.password) return { error: 'callback_url must not contain credentials' }
+  return { url: url.toString() }
+}
+
+function newSecret(): string {
+  const bytes = crypto.getRandomValues(new Uint8Array(32))
+  return 'whsec_' + Array.from(bytes, b => b.toString(16).padStart(2, '0')).join('')
+}
+
+export async function getCallbackSecret(kv: KVNamespace, token: string): Promise<string> {
+  const existing = await kv.get(`callbacksecret:${token}`)
+  if (existing) return existing
+  return rotateCallbackSecret(kv, token)
+}
+
+export async function rotateCallbackSecret(kv: KVNamespace, token: string): Promise<string> {
+  const secret = newSecret()
+  await kv.put(`callbacksecret:${token}`, secret)
+  return secret
+}
+
+export async function signPayload(secret: string, timestamp: string, body: string): Promise<string> {
+  const enc = new TextEncoder()
+  const key = await crypto.subtle.importKey('raw', enc.encode(secret), { name: 'HMAC', hash: 'SHA-256' }, false, ['sign'])
+  const sig = await crypto.subtle.sign('HMAC', key, enc.encode(`${timestamp}.${body}`))
+  return Array.from(new Uint8Array(sig), b => b.toString(16).padStart(2, '0')).join('')
+}
+
+/**
+ * POST a finished job to its callback URL. 5xx, 429 and network errors are retried with
+ * backoff; other 4xx answers are final. Never throws.
+ */
+export async function deliverCallback(kv: KVNamespace, token: string, url: string, job: object): Promise<CallbackResult> {
+  const body = JSON.stringify(job)
+  const secret = await getCallbackSecret(kv, token)
+  const result: CallbackResult = { url, delivered: false, attempts: 0 }
+
+  for (let attempt = 0; attempt < MAX_ATTEMPTS; attempt++) {
+    if (attempt > 0) await new Promise(r => setTimeout(r, backoffMs(attempt)))
+    result.attempts = attempt + 1
+    const timestamp = String(Math.floor(Date.now() / 1000))
+    try {
+      const res = await fetch(url, {
+        method: 'POST',
+        headers: {
+          'Content-Type': 'application/json',
+          'User-Agent': 'chomp-callback',
+          'X-Chomp-Timestamp': timestamp,
+          'X-Chomp-Signature': `sha256=${await signPayload(secret, timestamp, body)}`,
+        },
+        body,
+        signal: AbortSignal.timeout(CALLBACK_TIMEOUT_MS),
+      })
+      result.status = res.status
+      delete result.error
+      if (res.ok) {
+        result.delivered = true
+        return result
+      }
+      if (res.status < 500 && res.status !== 429) return result
+    } catch (err) {
+      result.error = err instanceof Error ? err.message : String(err)
+    }
+  }
+  console.warn(`[callback] ${url}: gave up after ${result.attempts} attempts`)
+  return result
+}
@@ -0,0 +1,88 @@
+// Chunked summarization for prompts that don't fit the chosen model's context window
+
+import { complete } from './dispatch'
+import type { Target } from './dispatch'
+import { estimateTokens } from './context'
+
+/** Share of the window a prompt may use; the rest is left for the system prompt and the answer. */
+const PROMPT_BUDGET = 0.6
+/** Chunks are sized so a chunk plus its summary instructions fit the summarizer comfortably. */
+const CHUNK_BUDGET = 0.5
+const MAX_CHUNKS = 16
+
+const SUMMARIZE_SYSTEM =
+  'You condense one part of a longer input so another model can work from it. ' +
+  'Keep every instruction, question, name, number, code identifier and error message; ' +
+  'drop repetition and filler. Reply with the condensed text only.'
+
+export interface Compression {
+  original_tokens: number
+  final_tokens: number
+  context_window: number
+  chunks: number
+  summarizer: string
+  tokens_in: number
+  tokens_out: number
+}
+
+export function needsCompression(prompt: string, system: string, contextWindow: number): boolean {
+  if (contextWindow <= 0) return false
+  return estimateTokens(prompt) + estimateTokens(system) > contextWindow * PROMPT_BUDGET
+}
+
+function splitChunks(text: string, maxChars: number): string[] {
+  const chunks: string[] = []
+  let rest = text
+  while (rest.length > maxChars) {
+    // Prefer to cut at a paragraph or line break in the back half of the chunk
+    let cut = rest.lastIndexOf('\n\n', maxChars)
+    if (cut < maxChars / 2) cut = rest.lastIndexOf('\n', maxChars)
+    if (cut < maxChars / 2) cut = maxChars
+    chunks.push(rest.slice(0, cut))
+    rest = rest.slice(cut)
+  }
+  if (rest.trim()) chunks.push(rest)
+  return chunks
+}
+
+/**
+ * Summarize a long prompt chunk by chunk with the summarizer target and return the
+ * condensed prompt plus what was done. Throws if any chunk fails to summarize.
+ */
+export async function compressPrompt(params: {
+  prompt: string
+  contextWindow: number
+  summarizer: Target
+  summarizerWindow: number
+}): Promise<{ prompt: string; compression: Compression }> {
+  const { prompt, contextWindow, summarizer } = params
+  const summarizerWindow = params.summarizerWindow || contextWindow
+  const chunkChars = Math.max(2000, Math.floor(summarizerWindow * CHUNK_BUDGET) * 4)
+  const chunks = splitChunks(prompt, chunkChars)
+  if (chunks.length > MAX_CHUNKS) {
+    throw new Error(`prompt too long to compress (${chunks.length} chunks, max ${MAX_CHUNKS})`)
+  }
+
+  const outs = await Promise.all(chunks.map((chunk, i) =>
+    complete(summarizer, `Part ${i + 1} of ${chunks.length}:\n\n${chunk}`, SUMMARIZE_SYSTEM)))
+  const failed = outs.find(o => o.status === 'error')
+  if (failed) throw new Error(`summarization failed: ${failed.error}`)
+
+  const condensed =
+    `The original input was too long for the model, so it was condensed in ${chunks.length} parts. ` +
+    `Treat the parts below as the full input.\n\n` +
+    outs.map((o, i) => `[Part ${i + 1}]\n${o.result}`).join('\n\n')
+
+  return {
+    prompt: condensed,
+    compression: {
+      original_tokens: estimateTokens(prompt),
+      final_tokens: estimateTokens(condensed),
+      context_window: contextWindow,
+      chunks: chunks.length,
+      summarizer: `${summarizer.router.id}/${summarizer.model}`,
+      tokens_in: outs.reduce((n, o) => n + o.tokens_in, 0),
+      tokens_out: outs.reduce((n, o) => n + o.tokens_out, 0),
+    },
+  }
+}
@@ -0,0 +1,25 @@
+// Context-window helpers: rough token estimates and per-model window lookup
+
+import type { RouterDef } from './routers'
+import { findModelInfo } from './models'
+
+/** Rough token count (~4 characters per token) — good enough to decide whether a prompt fits. */
+export function estimateTokens(text: string): number {
+  return Math.ceil(text.length / 4)
+}
+
+/** Token estimate for a chat message list, including a little per-message overhead. */
+export function estimateMessageTokens(messages: Array<{ role: string; content: string | null }>): number {
+  return messages.reduce((n, m) => n + estimateTokens(m.content ?? '') + 4, 0)
+}
+
+/** Look up a model's context window from the (cached) model metadata; 0 when unknown. */
+export async function lookupContextWindow(params: {
+  router: RouterDef
+  model: string
+  apiKey?: string | null
+  settings?: Record<string, string>
+}): Promise<number> {
+  const info = await findModelInfo(params)
+  return info?.context_length ?? 0
+}
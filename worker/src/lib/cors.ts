@@ -0,0 +1,29 @@
+/**
+ * CORS for /v1/* and /api/*. `CORS_ORIGINS` is "*" (default) or a comma-separated list of
+ * allowed origins, e.g. "https://app.example.com,http://localhost:5173".
+ */
+
+const ALLOW_METHODS = 'GET, POST, PUT, DELETE, OPTIONS'
+const ALLOW_HEADERS = 'Authorization, Content-Type, X-Provider-Key, X-Chomp-Client, X-Max-Wait, X-Chomp-Timeout, X-Bundle-Passphrase'
+
+export function corsHeaders(request: Request, allowedOrigins = '*'): Record<string, string> {
+  const allowed = allowedOrigins.split(',').map(o => o.trim()).filter(Boolean)
+  const origin = request.headers.get('Origin')
+  const headers: Record<string, string> = {
+    'Access-Control-Allow-Methods': ALLOW_METHODS,
+    'Access-Control-Allow-Headers': request.headers.get('Access-Control-Request-Headers') || ALLOW_HEADERS,
+    'Access-Control-Max-Age': '86400',
+  }
+
+  if (allowed.length === 0 || allowed.includes('*')) {
+    headers['Access-Control-Allow-Origin'] = '*'
+  } else if (origin && allowed.includes(origin)) {
+    headers['Access-Control-Allow-Origin'] = origin
+    headers['Vary'] = 'Origin'
+  }
+  return headers
+}
+
+export function isCorsPath(pathname: string): boolean {
+  return pathname.startsWith('/v1/') || pathname.startsWith('/api/') || pathname.startsWith('/proxy/')
+}
@@ -0,0 +1,269 @@
+// Shared dispatch logic: router/model resolution and a single upstream completion
+
+import type { UserRecord } from './auth'
+import { getUserKey, getUserSettings, getFirstAvailableRouter } from './auth'
+import type { RouterDef } from './routers'
+import { routers, getRouter, resolveRouterAndModel, missingSettings, callRouter, DEFAULT_TIMEOUT_MS } from './routers'
+import type { ModelPolicy } from './policy'
+import { emptyPolicy } from './policy'
+import { scanFreeModels, hasExplicitFreeModels } from './free'
+import type { FreeModel } from './free'
+import { findModelInfo } from './models'
+import type { RateLimit } from './ratelimit'
+import { retryDelayMs, sleep } from './ratelimit'
+import type { ErrorClass } from './errors'
+import { classifyError, classifyException, isRetryable, backoffMs, MAX_RETRIES } from './errors'
+
+/** OpenRouter free models that pass the user's policy, largest context first. */
+export async function listFreeModelIds(policy: ModelPolicy = emptyPolicy): Promise<string[]> {
+  const models = await scanFreeModels({ router: getRouter('openrouter')!, policy })
+  return models.map(m => m.id)
+}
+
+/** Largest-context free model on a router (OpenRouter by default). */
+export async function pickBestFreeModel(
+  policy: ModelPolicy = emptyPolicy,
+  router: RouterDef = getRouter('openrouter')!,
+  apiKey?: string | null,
+  settings?: Record<string, string>,
+): Promise<string> {
+  const free = await scanFreeModels({ router, apiKey, settings, policy })
+  if (!free.length) throw new Error('No free models available (check your model policy)')
+  return free[0].id
+}
+
+export interface Target {
+  router: RouterDef
+  model: string
+  apiKey: string | null
+  settings: Record<string, string>
+  /** Why "auto" resolved to this model, when it did. */
+  reason?: string
+}
+
+export interface TargetError {
+  error: string
+  status: number
+}
+
+/** Room left for the answer when checking whether a prompt fits a context window. */
+const OUTPUT_RESERVE = 1024
+
+/**
+ * Find a model on one router whose context window fits `needed` tokens: the router's
+ * default if it fits (or its window is unknown), else the smallest-window free model that fits.
+ */
+async function fitModel(
+  router: RouterDef,
+  apiKey: string | null,
+  settings: Record<string, string>,
+  policy: ModelPolicy,
+  needed: number,
+): Promise<{ model: string; reason: string } | null> {
+  if (router.defaultModel !== 'auto') {
+    const info = await findModelInfo({ router, model: router.defaultModel, apiKey, settings })
+    if (!info?.context_length) {
+      return { model: router.defaultModel, reason: `default model (context window unknown, prompt ~${needed} tokens)` }
+    }
+    if (info.context_length >= needed) {
+      return { model: router.defaultModel, reason: `default model fits (~${needed} of ${info.context_length} tokens)` }
+    }
+  }
+
+  let free: FreeModel[] = []
+  try {
+    free = await scanFreeModels({ router, apiKey, settings, policy })
+  } catch {
+    // listing unavailable — nothing we can prove fits
+  }
+  const fits = free.filter(m => m.context_length >= needed)
+  if (fits.length === 0) return null
+  const pick = fits[fits.length - 1]
+  return { model: pick.id, reason: `smallest free model that fits (~${needed} of ${pick.context_length} tokens)` }
+}
+
+/**
+ * Resolve a router + model pair for a user:
+ * explicit router → model prefix (e.g. "groq/llama-3.3-70b") → first router with a key.
+ *
+ * "auto" with a `promptTokens` estimate picks a model whose context window fits the
+ * prompt, trying the user's other routers when the first has none (unless the router
+ * was named). Without an estimate — or when nothing is known to fit — "auto" resolves to
+ * the router's best free model where free models are explicitly marked (OpenRouter, Zen),
+ * otherwise to the router's default. `reason` says which rule chose the model.
+ *
+ * `prefer` replaces the default router priority order when no router is named
+ * (see routerPreference in balance.ts).
+ *
+ * `apiKey` is a caller-supplied upstream key (X-Provider-Key). It is only ever sent to the
+ * router the caller named, so it requires an explicit router and skips the fallback to
+ * other routers.
+ */
+export async function resolveTarget(
+  user: UserRecord,
+  input: { router?: string; model?: string; promptTokens?: number; prefer?: string[]; apiKey?: string },
+  policy: ModelPolicy = emptyPolicy,
+): Promise<Target | TargetError> {
+  let routerId: string | undefined = input.router
+  let model = input.model || 'auto'
+
+  if (!routerId && model !== 'auto') {
+    const resolved = resolveRouterAndModel(model)
+    if (resolved.router) {
+      routerId = resolved.router
+      model = resolved.model
+    }
+  }
+
+  const explicitRouter = routerId !== undefined
+  if (input.apiKey && !explicitRouter) {
+    return { error: 'a provider key needs an explicit router ("router" or a "router/model" model)', status: 400 }
+  }
+  const order = input.prefer?.length ? input.prefer : routers.map(r => r.id)
+  if (!routerId) {
+    routerId = getFirstAvailableRouter(user, order) ?? undefined
+  }
+
+  if (!routerId) {
+    return { error: 'No router available — configure at least one API key', status: 400 }
+  }
+
+  const routerDef = getRouter(routerId)
+  if (!routerDef) {
+    return { error: `Unknown router: ${routerId}`, status: 400 }
+  }
+
+  const apiKey = input.apiKey ?? getUserKey(user, routerId)
+  const settings = getUserSettings(user, routerId)
+  if (model !== 'auto') {
+    return { router: routerDef, model, apiKey, settings }
+  }
+
+  if (input.promptTokens !== undefined) {
+    const needed = input.promptTokens + OUTPUT_RESERVE
+    const candidates = explicitRouter
+      ? [routerDef]
+      : [routerDef, ...order.flatMap(id => getRouter(id) ?? []).filter(r =>
+          r.id !== routerDef.id && user.keys[r.id] && missingSettings(r, getUserSettings(user, r.id)).length === 0)]
+    for (const r of candidates) {
+      const rKey = r === routerDef ? apiKey : getUserKey(user, r.id)
+      const rSettings = getUserSettings(user, r.id)
+      const fit = await fitModel(r, rKey, rSettings, policy, needed)
+      if (!fit) continue
+      const reason = r === routerDef ? fit.reason : `nothing on ${routerDef.name} fits; ${fit.reason} on ${r.name}`
+      return { router: r, model: fit.model, apiKey: rKey, settings: rSettings, reason }
+    }
+  }
+
+  let reason: string
+  if (hasExplicitFreeModels(routerDef)) {
+    try {
+      model = await pickBestFreeModel(policy, routerDef, apiKey, settings)
+      reason = 'largest-context free model'
+    } catch (e) {
+      if (routerDef.defaultModel === 'auto') return { error: (e as Error).message, status: 502 }
+      model = routerDef.defaultModel
+      reason = 'default model (free model listing unavailable)'
+    }
+  } else {
+    model = routerDef.defaultModel
+    reason = 'default model'
+  }
+  if (input.promptTokens !== undefined) reason += ` — no model known to fit ~${input.promptTokens} tokens`
+
+  return { router: routerDef, model, apiKey, settings, reason }
+}
+
+export function isTargetError(t: Target | TargetError): t is TargetError {
+  return 'error' in t
+}
+
+/** A target as accepted in request bodies: "router/model" or `{ router, model }`. */
+export type TargetInput = string | { router?: string; model?: string }
+
+/** Resolve a list of targets, stopping at the first one that fails. */
+export async function resolveTargets(
+  user: UserRecord,
+  inputs: TargetInput[],
+  policy: ModelPolicy = emptyPolicy,
+): Promise<Target[] | (TargetError & { target: TargetInput })> {
+  const targets: Target[] = []
+  for (const input of inputs) {
+    const spec = typeof input === 'string' ? { model: input } : input
+    const target = await resolveTarget(user, spec ?? {}, policy)
+    if (isTargetError(target)) return { ...target, target: input }
+    targets.push(target)
+  }
+  return targets
+}
+
+export interface Completion {
+  status: 'done' | 'error'
+  result: string
+  error: string
+  tokens_in: number
+  tokens_out: number
+  latency_ms: number
+  error_class?: ErrorClass
+  attempts?: number
+  rate_limit?: RateLimit
+}
+
+/** Longest rate-limit wait worth sitting out inside a background job. */
+const MAX_RATE_LIMIT_WAIT_MS = 10_000
+
+/**
+ * Run one prompt against a resolved target. Never throws — failures come back as status
+ * 'error' with an `error_class`. Rate limits, transient 5xx and timeouts are retried up to
+ * MAX_RETRIES times with jittered backoff; auth, bad-request and content-filter errors aren't.
+ */
+export async function complete(target: Target, prompt: string, system?: string): Promise<Completion> {
+  const out: Completion = { status: 'error', result: '', error: '', tokens_in: 0, tokens_out: 0, latency_ms: 0 }
+  if (!target.apiKey) {
+    out.error = `No ${target.router.name} key configured`
+    out.error_class = 'auth'
+    return out
+  }
+
+  const messages: { role: string; content: string }[] = []
+  if (system) messages.push({ role: 'system', content: system })
+  messages.push({ role: 'user', content: prompt })
+
+  const start = Date.now()
+  for (let attempt = 0; ; attempt++) {
+    out.attempts = attempt + 1
+    try {
+      const data = await callRouter({
+        router: target.router,
+        apiKey: target.apiKey,
+        model: target.model,
+        messages,
+        settings: target.settings,
+        signal: AbortSignal.timeout(target.router.timeoutMs ?? DEFAULT_TIMEOUT_MS),
+        onRateLimit: rl => { out.rate_limit = rl },
+      })
+
+      if (data.error) {
+        out.error = data.error.message || `${target.router.name} error`
+        out.error_class = data.error.class ?? classifyError(data.error)
+      } else {
+        out.status = 'done'
+        out.error = ''
+        delete out.error_class
+        out.result = data.choices?.[0]?.message?.content || ''
+        out.tokens_in = data.usage?.prompt_tokens || 0
+        out.tokens_out = data.usage?.completion_tokens || 0
+      }
+    } catch (e) {
+      out.error = (e as Error).message
+      out.error_class = classifyException(e)
+    }
+
+    if (out.status === 'done' || attempt >= MAX_RETRIES || !isRetryable(out.error_class!)) break
+    const delay = out.error_class === 'rate_limit' ? retryDelayMs(out.rate_limit) : backoffMs(attempt)
+    if (delay > MAX_RATE_LIMIT_WAIT_MS) break
+    await sleep(delay)
+  }
+  out.latency_ms = Date.now() - start
+  return out
+}
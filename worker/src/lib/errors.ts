@@ -0,0 +1,51 @@
+/**
+ * Upstream error classification. Providers disagree on error shapes (numeric vs string
+ * codes, HTML bodies from gateways), so errors are bucketed by HTTP status first and
+ * message patterns second. Only `rate_limit`, `transient` and `timeout` are worth retrying.
+ */
+
+export type ErrorClass = 'rate_limit' | 'auth' | 'transient' | 'timeout' | 'content_filter' | 'bad_request' | 'unknown'
+
+const PATTERNS: Array<[ErrorClass, RegExp]> = [
+  ['rate_limit', /rate.?limit|too many requests|quota|resource.?exhausted/i],
+  ['auth', /invalid.{0,10}(api.?)?key|unauthori[sz]ed|authenticat|permission|forbidden|no auth/i],
+  ['content_filter', /content.?(filter|policy|management)|safety|moderation|flagged/i],
+  ['timeout', /timed? ?out|deadline/i],
+  ['transient', /overloaded|unavailable|bad gateway|internal (server )?error|temporar|try again|capacity|upstream/i],
+]
+
+export function classifyError(error: { message?: string; type?: string; code?: string | number | null; status?: number }): ErrorClass {
+  const status = error.status ?? (typeof error.code === 'number' ? error.code : Number(error.code) || undefined)
+  if (status === 429) return 'rate_limit'
+  if (status === 401 || status === 403) return 'auth'
+  if (status === 408 || status === 504) return 'timeout'
+  if (status !== undefined && status >= 500) return 'transient'
+
+  const text = `${error.type ?? ''} ${error.code ?? ''} ${error.message ?? ''}`
+  for (const [cls, re] of PATTERNS) if (re.test(text)) return cls
+  if (status !== undefined && status >= 400) return 'bad_request'
+  return 'unknown'
+}
+
+/** Classify a thrown fetch error (network failure or abort). */
+export function classifyException(err: unknown): ErrorClass {
+  if (err instanceof DOMException && (err.name === 'AbortError' || err.name === 'TimeoutError')) return 'timeout'
+  return 'transient'
+}
+
+export function isRetryable(cls: ErrorClass): boolean {
+  return cls === 'rate_limit' || cls === 'transient' || cls === 'timeout'
+}
+
+export const MAX_RETRIES = 2
+
+/** Exponential backoff with ±50% jitter: ~0.5s, ~1s, ~2s ... */
+export function backoffMs(attempt: number): number {
+  return Math.round(500 * 2 ** attempt * (0.5 + Math.random()))
+}
+
+/** Keep error text readable: strip HTML error pages and cap the length. */
+export function cleanErrorText(text: string, max = 300): string {
+  const plain = /<html|<!doctype/i.test(text) ? text.replace(/<[^>]+>/g, ' ').replace(/\s+/g, ' ').trim() : text.trim()
+  return plain.length > max ? `${plain.slice(0, max)}…` : plain
+}
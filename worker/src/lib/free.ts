@@ -0,0 +1,66 @@
+// Free-model scanner: find free / zero-cost models on every router, not just OpenRouter
+
+import type { RouterDef } from './routers'
+import type { ModelPolicy } from './policy'
+import { emptyPolicy, allowedByPolicy } from './policy'
+import { getModelInfo } from './models'
+import type { ModelInfo } from './models'
+
+export interface FreeModel {
+  id: string
+  router: string
+  name: string
+  context_length: number
+  max_output: number
+}
+
+function zeroPricing(m: ModelInfo): boolean {
+  return m.pricing !== null && m.pricing.prompt === 0 && m.pricing.completion === 0
+}
+
+/**
+ * How each router marks free models. `explicit` routers tag free models individually,
+ * so auto selection can pick among them; the rest fall back to their default model.
+ * Routers not listed here count a model as free only when its pricing is zero.
+ */
+const detectors: Record<string, { explicit: boolean; isFree: (m: ModelInfo) => boolean }> = {
+  openrouter: { explicit: true, isFree: m => m.id.endsWith(':free') || zeroPricing(m) },
+  zen: { explicit: true, isFree: m => m.id.endsWith('-free') || zeroPricing(m) },
+  // Groq's free tier covers every model (rate-limited); /models has no pricing
+  groq: { explicit: false, isFree: m => m.active },
+}
+
+export function hasExplicitFreeModels(router: RouterDef): boolean {
+  return detectors[router.id]?.explicit ?? false
+}
+
+/** Skip tiny models that tend to return garbage, unless they're also big (MoE names like "8x7b"). */
+function worthUsing(m: ModelInfo): boolean {
+  const name = m.name.toLowerCase()
+  const tiny = ['1b', '3b', '7b', '8b'].some(s => name.includes(s))
+  const big = ['70b', '80b', '180b'].some(s => name.includes(s))
+  return !tiny || big
+}
+
+/** List a router's free models that pass the policy, largest context first. */
+export async function scanFreeModels(params: {
+  router: RouterDef
+  apiKey?: string | null
+  settings?: Record<string, string>
+  policy?: ModelPolicy
+}): Promise<FreeModel[]> {
+  const { router, policy = emptyPolicy } = params
+  const isFree = detectors[router.id]?.isFree ?? zeroPricing
+  return (await getModelInfo(params))
+    .filter(isFree)
+    .filter(worthUsing)
+    .map(m => ({
+      id: m.id,
+      router: router.id,
+      name: m.name,
+      context_length: m.context_length,
+      max_output: m.max_output,
+    }))
+    .filter(m => allowedByPolicy(policy, m))
+    .sort((a, b) => b.context_length - a.context_length)
+}
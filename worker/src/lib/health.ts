@@ -0,0 +1,50 @@
+/**
+ * Health probes for orchestrators and uptime monitors:
+ *
+ * - `/livez`, `/healthz`: the Worker answers (no I/O)
+ * - `/readyz`: KV round-trips a probe key and the router table is non-empty
+ *
+ * Provider keys are per user, so readiness doesn't depend on any one user's routers.
+ */
+
+import { routers } from './routers'
+import { getMode } from './admin'
+
+const PROBE_TTL = 60 // KV's minimum expiration
+
+export interface Check {
+  ok: boolean
+  detail?: string
+  latency_ms?: number
+}
+
+async function checkKv(kv: KVNamespace): Promise<Check> {
+  const start = Date.now()
+  const value = String(start)
+  try {
+    await kv.put('health:probe', value, { expirationTtl: PROBE_TTL })
+    // KV is eventually consistent across locations, but a same-location read sees the write
+    const read = await kv.get('health:probe')
+    return read === value
+      ? { ok: true, latency_ms: Date.now() - start }
+      : { ok: false, detail: 'probe read back a different value', latency_ms: Date.now() - start }
+  } catch (err) {
+    return { ok: false, detail: err instanceof Error ? err.message : String(err) }
+  }
+}
+
+export async function readiness(env: Env): Promise<{ ready: boolean; checks: Record<string, Check>; mode: string }> {
+  const checks: Record<string, Check> = {
+    kv: await checkKv(env.JOBS),
+    routers: routers.length > 0 ? { ok: true, detail: `${routers.length} defined` } : { ok: false, detail: 'no routers defined' },
+  }
+  const mode = await getMode(env).then(m => m.mode, () => 'unknown')
+  return { ready: Object.values(checks).every(c => c.ok), checks, mode }
+}
+
+export function probeResponse(body: object, status = 200): Response {
+  return new Response(JSON.stringify(body), {
+    status,
+    headers: { 'Content-Type': 'application/json', 'Cache-Control': 'no-store' },
+  })
+}
@@ -0,0 +1,60 @@
+/**
+ * In-flight limit for background jobs (dispatch, fanout, best). Each running job holds
+ * slots in KV under `inflight:{token}` (job ID → slots + start time) until it finishes;
+ * fanout and best-of-N hold one slot per upstream call. Interactive /v1 requests are never
+ * held back, so they always win over queued background work.
+ *
+ * KV is eventually consistent, so bursts can overshoot the limit slightly — it stops
+ * hundreds of parallel 120s calls, not the fifth one. Entries are dropped once older than
+ * the longest upstream timeout, so a crashed job can't hold slots forever.
+ */
+
+import { MAX_TIMEOUT_MS } from './routers'
+
+export const DEFAULT_MAX_INFLIGHT = 10
+const STALE_MS = MAX_TIMEOUT_MS * 3 + 60_000 // retries included
+
+type Inflight = Record<string, { slots: number; started: number }>
+
+export function inflightLimit(env: Env): number {
+  const n = Number(env.MAX_INFLIGHT)
+  return Number.isInteger(n) && n > 0 ? n : DEFAULT_MAX_INFLIGHT
+}
+
+async function read(kv: KVNamespace, token: string): Promise<Inflight> {
+  const raw = await kv.get(`inflight:${token}`)
+  const all: Inflight = raw ? JSON.parse(raw) : {}
+  const now = Date.now()
+  return Object.fromEntries(Object.entries(all).filter(([, e]) => now - e.started < STALE_MS))
+}
+
+export async function inflightSlots(kv: KVNamespace, token: string): Promise<{ jobs: number; slots: number }> {
+  const entries = Object.values(await read(kv, token))
+  return { jobs: entries.length, slots: entries.reduce((n, e) => n + e.slots, 0) }
+}
+
+/** Take `slots` for a job, or return a 429 response when that would exceed the limit. */
+export async function acquireSlots(kv: KVNamespace, token: string, id: string, slots: number, limit: number): Promise<Response | null> {
+  const inflight = await read(kv, token)
+  const used = Object.values(inflight).reduce((n, e) => n + e.slots, 0)
+  if (used + slots > limit) {
+    return new Response(JSON.stringify({
+      error: `too many jobs in flight (${used} of ${limit} slots busy, this job needs ${slots})`,
+      inflight: used,
+      limit,
+    }), { status: 429, headers: { 'Content-Type': 'application/json', 'Retry-After': '5' } })
+  }
+  inflight[id] = { slots, started: Date.now() }
+  await kv.put(`inflight:${token}`, JSON.stringify(inflight))
+  return null
+}
+
+export async function releaseSlots(kv: KVNamespace, token: string, id: string): Promise<void> {
+  try {
+    const inflight = await read(kv, token)
+    delete inflight[id]
+    await kv.put(`inflight:${token}`, JSON.stringify(inflight))
+  } catch (err) {
+    console.warn('[inflight] release failed:', err)
+  }
+}
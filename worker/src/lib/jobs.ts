@@ -0,0 +1,121 @@
+/**
+ * Jobs: dispatched prompts are stored in KV as `job:{token}:{id}` with a 24h TTL.
+ * `jobindex:{token}` holds the newest 500 job IDs for listing.
+ */
+
+import type { Compression } from './compress'
+import type { Redaction } from './redact'
+import type { ErrorClass } from './errors'
+import type { CallbackResult } from './callback'
+import type { GuardrailResult } from './guardrails'
+import type { TransformResult } from './transforms'
+import { redactJob } from './redact'
+
+export const JOB_TTL = 86400
+export const JOB_INDEX_LIMIT = 500
+
+export interface Job {
+  id: string
+  prompt: string
+  system: string
+  preset?: string
+  model: string
+  router: string
+  status: string
+  result: string
+  error: string
+  error_class?: ErrorClass
+  attempts?: number
+  tokens_in: number
+  tokens_out: number
+  created: string
+  finished: string
+  latency_ms: number
+  compression?: Compression | null
+  tags?: string[]
+  metadata?: JobMetadata
+  callback_url?: string
+  callback?: CallbackResult
+  guardrails?: GuardrailResult[]
+  transforms?: Omit<TransformResult, 'text'>
+}
+
+export type JobMetadata = Record<string, string | number | boolean>
+
+const MAX_TAGS = 10
+const MAX_METADATA_KEYS = 20
+const MAX_METADATA_BYTES = 2048
+const LABEL_RE = /^[\w.:@/-]{1,64}$/
+
+/**
+ * Validate caller-supplied `tags` and `metadata` from a dispatch body. Tags are short labels;
+ * metadata is a flat map of scalars for correlating jobs with the caller's own entities.
+ */
+export function parseJobLabels(body: { tags?: unknown; metadata?: unknown }): { tags: string[]; metadata: JobMetadata } | { error: string } {
+  const tags = body.tags ?? []
+  if (!Array.isArray(tags) || tags.some(t => typeof t !== 'string' || !LABEL_RE.test(t))) {
+    return { error: 'tags must be an array of strings (letters, digits, ._:@/-, max 64 chars)' }
+  }
+  if (tags.length > MAX_TAGS) return { error: `at most ${MAX_TAGS} tags` }
+
+  const metadata = body.metadata ?? {}
+  if (typeof metadata !== 'object' || Array.isArray(metadata) || metadata === null) {
+    return { error: 'metadata must be an object' }
+  }
+  const entries = Object.entries(metadata)
+  if (entries.length > MAX_METADATA_KEYS) return { error: `at most ${MAX_METADATA_KEYS} metadata keys` }
+  if (entries.some(([k, v]) => !LABEL_RE.test(k) || !['string', 'number', 'boolean'].includes(typeof v))) {
+    return { error: 'metadata keys must be short labels and values strings, numbers or booleans' }
+  }
+  if (JSON.stringify(metadata).length > MAX_METADATA_BYTES) return { error: `metadata must be under ${MAX_METADATA_BYTES} bytes` }
+
+  return { tags: [...new Set(tags as string[])], metadata: metadata as JobMetadata }
+}
+
+const CROCKFORD = '0123456789ABCDEFGHJKMNPQRSTVWXYZ'
+
+/**
+ * A ULID: 48-bit millisecond timestamp + 80 random bits, Crockford base32. IDs sort by
+ * creation time and don't collide across isolates. Older base36 IDs still resolve, since
+ * lookups are by exact key.
+ */
+export function newJobId(): string {
+  let time = Date.now()
+  let out = ''
+  for (let i = 0; i < 10; i++) {
+    out = CROCKFORD[time % 32] + out
+    time = Math.floor(time / 32)
+  }
+  for (const b of crypto.getRandomValues(new Uint8Array(16))) out += CROCKFORD[b % 32]
+  return out
+}
+
+/** Store a job, redacting prompt/result text first when the user asked for it. */
+export async function putJob(kv: KVNamespace, token: string, job: { id: string }, redaction?: Redaction): Promise<void> {
+  const stored = redaction ? await redactJob(job, redaction) : job
+  await kv.put(`job:${token}:${job.id}`, JSON.stringify(stored), { expirationTtl: JOB_TTL })
+}
+
+/** `?tag=a&tag=b` (all must match) and `?meta.key=value` filters for job listings. */
+export function matchesLabels(job: { tags?: string[]; metadata?: JobMetadata }, params: URLSearchParams): boolean {
+  if (!params.getAll('tag').every(t => job.tags?.includes(t))) return false
+  for (const [key, value] of params) {
+    if (key.startsWith('meta.') && String(job.metadata?.[key.slice(5)]) !== value) return false
+  }
+  return true
+}
+
+export async function getJob<T = Job>(kv: KVNamespace, token: string, id: string): Promise<T | null> {
+  const raw = await kv.get(`job:${token}:${id}`)
+  return raw ? (JSON.parse(raw) as T) : null
+}
+
+/** Prepend a job ID to the user's job index, keeping the newest JOB_INDEX_LIMIT. */
+export async function indexJob(kv: KVNamespace, token: string, id: string): Promise<void> {
+  const indexKey = `jobindex:${token}`
+  const indexRaw = await kv.get(indexKey)
+  const index: string[] = indexRaw ? JSON.parse(indexRaw) : []
+  index.unshift(id)
+  if (index.length > JOB_INDEX_LIMIT) index.length = JOB_INDEX_LIMIT
+  await kv.put(indexKey, JSON.stringify(index))
+}
@@ -0,0 +1,87 @@
+// Best-of-N: generate candidate answers across models and let a judge model pick one
+
+import type { UserRecord } from './auth'
+import { routers } from './routers'
+import { listFreeModelIds, complete } from './dispatch'
+import type { Target, TargetInput } from './dispatch'
+import type { ModelPolicy } from './policy'
+import { emptyPolicy } from './policy'
+
+export const DEFAULT_CANDIDATES = 3
+export const MAX_CANDIDATES = 6
+
+const JUDGE_SYSTEM =
+  'You are an impartial judge comparing answers from different AI models to the same prompt. ' +
+  'Score each answer from 1 to 10 for correctness, completeness and clarity, then pick the best. ' +
+  'Reply with JSON only, no prose: {"winner": "A", "scores": {"A": 8, "B": 6}, "rationale": "..."}'
+
+/**
+ * Default candidate pool when the caller names no targets: each configured router's
+ * default model, topped up with OpenRouter free models.
+ */
+export async function defaultCandidates(
+  user: UserRecord,
+  n: number,
+  policy: ModelPolicy = emptyPolicy,
+): Promise<TargetInput[]> {
+  const inputs: TargetInput[] = routers
+    .filter(r => r.id !== 'openrouter' && user.keys[r.id])
+    .map(r => ({ router: r.id }))
+  if (user.keys.openrouter && inputs.length < n) {
+    try {
+      for (const id of await listFreeModelIds(policy)) inputs.push({ router: 'openrouter', model: id })
+    } catch {
+      // OpenRouter listing unavailable — judge whatever the other routers give us
+    }
+  }
+  return inputs.slice(0, n)
+}
+
+const label = (i: number) => String.fromCharCode(65 + i)
+
+export function buildJudgePrompt(prompt: string, answers: string[]): string {
+  const parts = [`## Prompt\n\n${prompt}`]
+  answers.forEach((a, i) => parts.push(`## Answer ${label(i)}\n\n${a}`))
+  return parts.join('\n\n')
+}
+
+export interface Verdict {
+  winner: number
+  scores: number[]
+  rationale: string
+}
+
+/** Parse the judge's JSON reply; tolerates code fences and surrounding chatter. */
+export function parseVerdict(text: string, n: number): Verdict | null {
+  const match = text.match(/\{[\s\S]*\}/)
+  if (!match) return null
+  let raw: { winner?: unknown; scores?: Record<string, unknown>; rationale?: unknown }
+  try {
+    raw = JSON.parse(match[0])
+  } catch {
+    return null
+  }
+  const winner = typeof raw.winner === 'string' ? raw.winner.trim().toUpperCase().charCodeAt(0) - 65 : -1
+  if (winner < 0 || winner >= n) return null
+  const scores = Array.from({ length: n }, (_, i) => Number(raw.scores?.[label(i)]) || 0)
+  return { winner, scores, rationale: typeof raw.rationale === 'string' ? raw.rationale : '' }
+}
+
+/** Ask the judge to pick among answers. Returns null verdict with an error when judging fails. */
+export async function judge(
+  target: Target,
+  prompt: string,
+  answers: string[],
+): Promise<{ verdict: Verdict | null; error: string; tokens_in: number; tokens_out: number }> {
+  const out = await complete(target, buildJudgePrompt(prompt, answers), JUDGE_SYSTEM)
+  if (out.status === 'error') {
+    return { verdict: null, error: out.error, tokens_in: out.tokens_in, tokens_out: out.tokens_out }
+  }
+  const verdict = parseVerdict(out.result, answers.length)
+  return {
+    verdict,
+    error: verdict ? '' : 'judge reply was not a valid verdict',
+    tokens_in: out.tokens_in,
+    tokens_out: out.tokens_out,
+  }
+}
@@ -0,0 +1,53 @@
+/**
+ * Rolling latency per router/model from real traffic, kept in KV as `latency:{token}`:
+ * the last SAMPLE_LIMIT successful call latencies for each "router/model". Feeds the
+ * `fastest` balancing strategy and the `latency` block of /api/stats.
+ */
+
+export const SAMPLE_LIMIT = 50
+
+type Samples = Record<string, number[]>
+
+export interface LatencyStats {
+  p50: number
+  p95: number
+  samples: number
+}
+
+export async function getLatencySamples(kv: KVNamespace, token: string): Promise<Samples> {
+  const raw = await kv.get(`latency:${token}`)
+  return raw ? JSON.parse(raw) : {}
+}
+
+export async function recordLatency(kv: KVNamespace, token: string, key: string, ms: number): Promise<void> {
+  const samples = await getLatencySamples(kv, token)
+  const list = (samples[key] ??= [])
+  list.push(Math.round(ms))
+  if (list.length > SAMPLE_LIMIT) list.splice(0, list.length - SAMPLE_LIMIT)
+  await kv.put(`latency:${token}`, JSON.stringify(samples))
+}
+
+function percentile(sorted: number[], p: number): number {
+  return sorted[Math.min(sorted.length - 1, Math.floor(p * sorted.length))]
+}
+
+export function latencyStats(values: number[]): LatencyStats | null {
+  if (values.length === 0) return null
+  const sorted = [...values].sort((a, b) => a - b)
+  return { p50: percentile(sorted, 0.5), p95: percentile(sorted, 0.95), samples: sorted.length }
+}
+
+/** p50/p95 per "router/model". */
+export function latencyByModel(samples: Samples): Record<string, LatencyStats> {
+  return Object.fromEntries(Object.entries(samples).flatMap(([k, v]) => {
+    const s = latencyStats(v)
+    return s ? [[k, s]] : []
+  }))
+}
+
+/** p50/p95 per router, pooling the samples of all its models. */
+export function latencyByRouter(samples: Samples): Record<string, LatencyStats> {
+  const pooled: Samples = {}
+  for (const [key, values] of Object.entries(samples)) (pooled[key.split('/')[0]] ??= []).push(...values)
+  return latencyByModel(pooled)
+}
@@ -0,0 +1,101 @@
+// Model metadata: context window, max output and pricing from each router's /models listing
+
+import type { RouterDef } from './routers'
+import { resolveBaseUrl } from './routers'
+
+export const MODEL_CACHE_TTL = 15 * 60 // 15 minutes
+
+export interface ModelInfo {
+  id: string
+  router: string
+  name: string
+  created: number
+  owned_by: string
+  context_length: number // 0 when the upstream doesn't say
+  max_output: number // 0 when the upstream doesn't say
+  pricing: { prompt: number; completion: number } | null // USD per token
+  active: boolean
+}
+
+interface UpstreamModel {
+  id: string
+  name?: string
+  active?: boolean
+  created?: number
+  owned_by?: string
+  context_length?: number
+  context_window?: number
+  max_completion_tokens?: number
+  top_provider?: { context_length?: number; max_completion_tokens?: number }
+  pricing?: { prompt?: string | number; completion?: string | number }
+}
+
+function toInfo(router: RouterDef, m: UpstreamModel): ModelInfo {
+  const prompt = Number(m.pricing?.prompt)
+  const completion = Number(m.pricing?.completion)
+  return {
+    id: m.id,
+    router: router.id,
+    name: m.name ?? m.id,
+    created: m.created ?? 0,
+    owned_by: m.owned_by ?? router.id,
+    context_length: m.context_length ?? m.context_window ?? m.top_provider?.context_length ?? 0,
+    max_output: m.top_provider?.max_completion_tokens ?? m.max_completion_tokens ?? 0,
+    pricing: Number.isFinite(prompt) && Number.isFinite(completion) ? { prompt, completion } : null,
+    active: m.active !== false,
+  }
+}
+
+async function sha256(text: string): Promise<string> {
+  const digest = await crypto.subtle.digest('SHA-256', new TextEncoder().encode(text))
+  return Array.from(new Uint8Array(digest)).map(b => b.toString(16).padStart(2, '0')).join('')
+}
+
+/**
+ * List a router's models with metadata. Cached per router + key (hashed) in the
+ * Workers cache, since listings differ per account on some providers.
+ */
+export async function getModelInfo(params: {
+  router: RouterDef
+  apiKey?: string | null
+  settings?: Record<string, string>
+}): Promise<ModelInfo[]> {
+  const { router, apiKey, settings } = params
+  const baseUrl = resolveBaseUrl(router, settings)
+  const cache = (caches as unknown as { default: Cache }).default
+  const cacheKey = new Request(`https://chomp-cache/models/${router.id}/${await sha256(`${baseUrl}|${apiKey ?? ''}`)}`)
+
+  const cached = await cache.match(cacheKey)
+  if (cached) return (await cached.json()) as ModelInfo[]
+
+  const headers: Record<string, string> = { ...router.headers }
+  if (apiKey) headers.Authorization = `Bearer ${apiKey}`
+  const res = await fetch(`${baseUrl}/models`, { headers })
+  if (!res.ok) throw new Error(`${router.name} /models: HTTP ${res.status} ${res.statusText}`)
+
+  const body = (await res.json()) as { data?: UpstreamModel[] }
+  const models = (body.data ?? []).map(m => toInfo(router, m))
+
+  await cache
+    .put(cacheKey, new Response(JSON.stringify(models), {
+      headers: { 'Content-Type': 'application/json', 'Cache-Control': `max-age=${MODEL_CACHE_TTL}` },
+    }))
+    .catch((err: unknown) => console.warn('[models] cache put failed:', err))
+
+  return models
+}
+
+/** Metadata for one model, or undefined if the router doesn't list it (or listing failed). */
+export async function findModelInfo(params: {
+  router: RouterDef
+  model: string
+  apiKey?: string | null
+  settings?: Record<string, string>
+}): Promise<ModelInfo | undefined> {
+  try {
+    const models = await getModelInfo(params)
+    return models.find(m => m.id === params.model)
+  } catch {
+    return undefined
+  }
+}
@@ -0,0 +1,79 @@
+/**
+ * Notifications: per-user webhook channels stored in KV as `notify:{token}`.
+ * Slack and Discord get a one-line message; `webhook` channels get the raw event as JSON.
+ * Delivery is best-effort — failures are logged, never surfaced to the job.
+ */
+
+import { z } from 'zod'
+
+export const NOTIFY_EVENTS = ['job.done', 'job.error', 'router.down', 'report.daily'] as const
+export type NotifyEvent = (typeof NOTIFY_EVENTS)[number]
+
+const ChannelSchema = z.object({
+  type: z.enum(['slack', 'discord', 'webhook']),
+  url: z.string().url().refine(u => u.startsWith('https://'), 'url must be https'),
+  events: z.array(z.enum(NOTIFY_EVENTS)).min(1).default([...NOTIFY_EVENTS]),
+}).strict()
+
+export const NotificationsSchema = z.object({
+  channels: z.array(ChannelSchema).max(10).default([]),
+}).strict()
+
+export type Notifications = z.infer<typeof NotificationsSchema>
+
+export const emptyNotifications: Notifications = { channels: [] }
+
+export async function getNotifications(kv: KVNamespace, token: string): Promise<Notifications> {
+  const raw = await kv.get(`notify:${token}`)
+  return raw ? { ...emptyNotifications, ...JSON.parse(raw) } : emptyNotifications
+}
+
+export async function saveNotifications(kv: KVNamespace, token: string, config: Notifications): Promise<void> {
+  await kv.put(`notify:${token}`, JSON.stringify(config))
+}
+
+export async function deleteNotifications(kv: KVNamespace, token: string): Promise<void> {
+  await kv.delete(`notify:${token}`)
+}
+
+function payload(type: Notifications['channels'][number]['type'], event: NotifyEvent, text: string, data: object): unknown {
+  if (type === 'slack') return { text }
+  if (type === 'discord') return { content: text }
+  return { event, text, ...data, at: new Date().toISOString() }
+}
+
+/** Send an event to every channel subscribed to it. */
+export async function notify(kv: KVNamespace, token: string, event: NotifyEvent, text: string, data: object = {}): Promise<void> {
+  const { channels } = await getNotifications(kv, token)
+  await Promise.all(channels
+    .filter(c => c.events.includes(event))
+    .map(async c => {
+      try {
+        const res = await fetch(c.url, {
+          method: 'POST',
+          headers: { 'Content-Type': 'application/json' },
+          body: JSON.stringify(payload(c.type, event, text, data)),
+        })
+        if (!res.ok) console.warn(`[notify] ${c.type} ${event}: HTTP ${res.status}`)
+      } catch (err) {
+        console.warn(`[notify] ${c.type} ${event}:`, err)
+      }
+    }))
+}
+
+/** job.done / job.error for a finished job of any kind. */
+export async function notifyJob(
+  kv: KVNamespace,
+  token: string,
+  job: { id: string; kind?: string; status: string; router?: string; model?: string; error: string; latency_ms: number },
+): Promise<void> {
+  const what = job.kind ? `${job.kind} job ${job.id}` : `job ${job.id}`
+  const via = job.router && job.model ? ` (${job.router}/${job.model})` : ''
+  const event: NotifyEvent = job.status === 'done' ? 'job.done' : 'job.error'
+  const text = job.status === 'done'
+    ? `chomp: ${what}${via} done in ${job.latency_ms}ms`
+    : `chomp: ${what}${via} failed: ${job.error}`
+  await notify(kv, token, event, text, {
+    job: { id: job.id, kind: job.kind ?? 'dispatch', status: job.status, router: job.router, model: job.model, error: job.error, latency_ms: job.latency_ms },
+  })
+}
@@ -0,0 +1,305 @@
+/**
+ * OpenAPI 3 description of the /api, /v1 and /proxy surfaces, served at /api/openapi.json.
+ * `endpoints` is the single route table — add an entry alongside any new route;
+ * `check-openapi.mjs` (CI and the pre-push gate) fails when it drifts from src/pages.
+ */
+
+type Schema = Record<string, unknown>
+
+export interface Endpoint {
+  method: 'get' | 'post' | 'put' | 'delete'
+  path: string // OpenAPI style: /api/result/{id}
+  summary: string
+  auth: 'user' | 'admin' | 'none'
+  query?: Record<string, string> // name -> description
+  body?: Schema
+}
+
+const str = { type: 'string' }
+const int = { type: 'integer' }
+const bool = { type: 'boolean' }
+
+const jobLabels = {
+  tags: { type: 'array', items: str, maxItems: 10 },
+  metadata: { type: 'object', additionalProperties: { type: ['string', 'number', 'boolean'] }, description: 'Flat map, max 20 keys' },
+}
+
+const dispatchBody: Schema = {
+  type: 'object',
+  required: ['prompt'],
+  properties: {
+    prompt: str,
+    system: str,
+    preset: { ...str, description: 'System prompt preset, "name" (latest) or "name@N"; goes before `system`' },
+    router: { ...str, description: 'Router ID; omitted = auto' },
+    model: { ...str, description: 'Model ID or "router/model"' },
+    compress: { ...bool, description: 'Summarize prompts that exceed the context window (default true)' },
+    summarizer: { ...str, description: 'Model used for compression (default: the target)' },
+    callback_url: { ...str, description: 'https URL that receives the finished job (HMAC-signed POST)' },
+    wait: { description: 'true (30s) or seconds (max 90) to hold the request and return the finished job; 202 if still running' },
+    ...jobLabels,
+  },
+}
+
+const targetList = { type: 'array', items: { type: 'string', description: '"router/model"' } }
+
+const chatBody: Schema = {
+  type: 'object',
+  required: ['messages'],
+  properties: {
+    model: { ...str, description: '"router/model", a bare model ID, or "auto"' },
+    messages: {
+      type: 'array',
+      items: { type: 'object', required: ['role', 'content'], properties: { role: str, content: str } },
+    },
+    stream: bool,
+    preset: { ...str, description: 'chomp extension: system prompt preset, "name" or "name@N"' },
+  },
+}
+
+export const endpoints: Endpoint[] = [
+  { method: 'post', path: '/v1/chat/completions', summary: 'OpenAI-compatible chat completion', auth: 'user', body: chatBody },
+  { method: 'get', path: '/v1/models', summary: 'Models from every configured router, with context length and pricing', auth: 'user' },
+  { method: 'post', path: '/api/dispatch', summary: 'Dispatch a prompt asynchronously; returns a job ID', auth: 'user', body: dispatchBody },
+  {
+    method: 'post', path: '/api/dispatch/fanout', summary: 'Send one prompt to several targets as one grouped job', auth: 'user',
+    body: { type: 'object', required: ['prompt', 'targets'], properties: { prompt: str, system: str, targets: targetList, ...jobLabels } },
+  },
+  {
+    method: 'post', path: '/api/dispatch/best', summary: 'Best-of-N across free models, picked by a judge model', auth: 'user',
+    body: { type: 'object', required: ['prompt'], properties: { prompt: str, system: str, n: int, targets: targetList, judge: str, ...jobLabels } },
+  },
+  {
+    method: 'post', path: '/api/pipelines', summary: 'Run a chain of prompts; {{input}}, {{prev}} and {{steps.NAME}} template earlier output', auth: 'user',
+    body: {
+      type: 'object',
+      required: ['steps'],
+      properties: {
+        input: str,
+        steps: {
+          type: 'array',
+          items: {
+            type: 'object',
+            required: ['prompt'],
+            properties: { name: str, prompt: str, system: str, preset: str, router: str, model: str },
+          },
+        },
+        ...jobLabels,
+      },
+    },
+  },
+  { method: 'get', path: '/api/result/{id}', summary: 'Poll a job', auth: 'user' },
+  {
+    method: 'get', path: '/api/jobs', summary: 'Recent jobs', auth: 'user',
+    query: {
+      status: 'running | done | error',
+      router: 'Router ID',
+      since: 'ISO timestamp (inclusive)',
+      until: 'ISO timestamp (exclusive)',
+      tag: 'Only jobs with this tag (repeatable; all must match)',
+      'meta.{key}': 'Only jobs whose metadata key equals the value',
+      limit: 'Page size (1-100, default 50)',
+      cursor: 'X-Next-Cursor from the previous page (a job ID)',
+    },
+  },
+  { method: 'get', path: '/api/stats', summary: 'Daily usage with per-router and per-source breakdowns, plus rolling p50/p95 latency', auth: 'user', query: { days: 'Days to include (1-90, default 7)' } },
+  {
+    method: 'get', path: '/api/reports/daily', summary: 'Daily usage report with a model-written digest', auth: 'user',
+    query: { date: 'YYYY-MM-DD (UTC, default today)', refresh: '"1" to rebuild instead of reading the cache' },
+  },
+  {
+    method: 'post', path: '/api/reports/daily', summary: 'Rebuild the daily report and send it to report.daily channels', auth: 'user',
+    body: { type: 'object', properties: { date: str } },
+  },
+  { method: 'get', path: '/api/usage/by-client', summary: 'Usage per X-Chomp-Client label, with per-router breakdowns', auth: 'user', query: { days: 'Days to include (1-90, default 7)' } },
+  {
+    method: 'post', path: '/api/keys', summary: 'Register provider keys; returns a chomp token', auth: 'none',
+    body: { type: 'object', properties: { keys: { type: 'object', additionalProperties: str }, settings: { type: 'object' } } },
+  },
+  { method: 'get', path: '/api/keys', summary: 'Configured routers and key previews', auth: 'user' },
+  { method: 'delete', path: '/api/keys', summary: 'Revoke the token', auth: 'user' },
+  { method: 'get', path: '/api/config/routers', summary: 'Per-router fields and configuration state', auth: 'user' },
+  { method: 'get', path: '/api/config/routers/{id}', summary: "One router's settings document", auth: 'user' },
+  {
+    method: 'put', path: '/api/config/routers/{id}', summary: "Replace one router's key and settings", auth: 'user',
+    body: { type: 'object', required: ['key'], properties: { key: str, settings: { type: 'object', additionalProperties: str }, test: bool } },
+  },
+  { method: 'delete', path: '/api/config/routers/{id}', summary: "Remove one router's key and settings", auth: 'user' },
+  { method: 'post', path: '/api/config/routers/{id}/test', summary: 'Test the stored key with real calls', auth: 'user', body: { type: 'object', properties: { model: str } } },
+  { method: 'get', path: '/api/config/keys/rotate', summary: 'Key rotation history', auth: 'user' },
+  {
+    method: 'put', path: '/api/config/keys/rotate', summary: "Swap a router's key after testing the new one", auth: 'user',
+    body: { type: 'object', required: ['router', 'key'], properties: { router: str, key: str } },
+  },
+  { method: 'get', path: '/api/config/models', summary: 'Model policy', auth: 'user' },
+  {
+    method: 'put', path: '/api/config/models', summary: 'Replace the model policy', auth: 'user',
+    body: {
+      type: 'object',
+      properties: { allow: { type: 'array', items: str }, deny: { type: 'array', items: str }, min_context: int, notes: { type: 'object', additionalProperties: str } },
+    },
+  },
+  { method: 'delete', path: '/api/config/models', summary: 'Reset the model policy', auth: 'user' },
+  { method: 'get', path: '/api/config/balancing', summary: 'Router load-balancing strategy', auth: 'user' },
+  {
+    method: 'put', path: '/api/config/balancing', summary: 'Set the load-balancing strategy and weights', auth: 'user',
+    body: { type: 'object', properties: { strategy: { type: 'string', enum: ['priority', 'weighted', 'lru', 'fastest'] }, weights: { type: 'object', additionalProperties: { type: 'number' } } } },
+  },
+  { method: 'delete', path: '/api/config/balancing', summary: 'Back to priority order', auth: 'user' },
+  { method: 'get', path: '/api/config/redaction', summary: 'Prompt/result redaction for stored jobs', auth: 'user' },
+  {
+    method: 'put', path: '/api/config/redaction', summary: 'Set prompt/result redaction', auth: 'user',
+    body: {
+      type: 'object',
+      properties: {
+        prompts: { type: 'string', enum: ['none', 'truncate', 'hash', 'drop'] },
+        results: { type: 'string', enum: ['none', 'truncate', 'hash', 'drop'] },
+        truncate_chars: int,
+      },
+    },
+  },
+  { method: 'delete', path: '/api/config/redaction', summary: 'Store prompts and results in full again', auth: 'user' },
+  { method: 'get', path: '/api/config/prompts', summary: 'System prompt presets (latest version of each)', auth: 'user' },
+  { method: 'get', path: '/api/config/prompts/{name}', summary: 'One preset with every version', auth: 'user' },
+  {
+    method: 'put', path: '/api/config/prompts/{name}', summary: 'Save the next version of a preset', auth: 'user',
+    body: { type: 'object', required: ['text'], properties: { text: str, description: str } },
+  },
+  { method: 'delete', path: '/api/config/prompts/{name}', summary: 'Delete a preset and its history', auth: 'user' },
+  { method: 'get', path: '/api/config/guardrails', summary: 'Prompt/response guardrails', auth: 'user' },
+  {
+    method: 'put', path: '/api/config/guardrails', summary: 'Set guardrails (denylist, max prompt length, moderation model)', auth: 'user',
+    body: {
+      type: 'object',
+      properties: {
+        enabled: bool,
+        action: { type: 'string', enum: ['reject', 'flag'] },
+        denylist: { type: 'array', items: { ...str, description: 'Case-insensitive regex' } },
+        max_prompt_chars: int,
+        moderation: { type: 'object', properties: { model: { ...str, description: '"router/model" that answers SAFE or UNSAFE' } } },
+        check_responses: bool,
+      },
+    },
+  },
+  { method: 'delete', path: '/api/config/guardrails', summary: 'Turn guardrails off', auth: 'user' },
+  { method: 'get', path: '/api/config/transforms', summary: 'Response post-processing rules', auth: 'user' },
+  {
+    method: 'put', path: '/api/config/transforms', summary: 'Set response post-processing rules', auth: 'user',
+    body: {
+      type: 'object',
+      properties: {
+        rules: {
+          type: 'array',
+          items: {
+            type: 'object',
+            required: ['transforms'],
+            properties: {
+              client: { ...str, description: 'X-Chomp-Client label; omitted = all' },
+              route: { type: 'string', enum: ['v1', 'dispatch'] },
+              transforms: {
+                type: 'array',
+                items: { type: 'object', required: ['type'], properties: { type: { type: 'string', enum: ['strip_fences', 'trim', 'json', 'prepend', 'append', 'replace', 'webhook'] } } },
+              },
+            },
+          },
+        },
+      },
+    },
+  },
+  { method: 'delete', path: '/api/config/transforms', summary: 'Remove all post-processing rules', auth: 'user' },
+  { method: 'get', path: '/api/prompts/recent', summary: 'Recently dispatched prompts, deduplicated, newest first', auth: 'user', query: { q: 'Substring filter' } },
+  { method: 'delete', path: '/api/prompts/recent', summary: 'Clear recent prompts', auth: 'user' },
+  { method: 'get', path: '/api/prompts/pinned', summary: 'Pinned prompts', auth: 'user' },
+  {
+    method: 'put', path: '/api/prompts/pinned', summary: 'Replace the pinned prompts', auth: 'user',
+    body: { type: 'object', properties: { prompts: { type: 'array', items: { type: 'object', required: ['prompt'], properties: { label: str, prompt: str } } } } },
+  },
+  { method: 'delete', path: '/api/prompts/pinned', summary: 'Remove all pinned prompts', auth: 'user' },
+  { method: 'get', path: '/api/keys/scoped', summary: 'Live scoped tokens', auth: 'user' },
+  {
+    method: 'post', path: '/api/keys/scoped', summary: 'Mint a short-lived token that only works on /v1', auth: 'user',
+    body: { type: 'object', required: ['label'], properties: { label: { ...str, description: 'Client label its usage is recorded under' }, ttl_s: int } },
+  },
+  { method: 'delete', path: '/api/keys/scoped', summary: 'Revoke a scoped token', auth: 'user', query: { id: 'Scoped token id' } },
+  { method: 'get', path: '/api/config/bundle', summary: 'Export every setting as one bundle', auth: 'user', query: { include_keys: '1 to include router keys (encrypted with X-Bundle-Passphrase if sent)' } },
+  { method: 'post', path: '/api/config/bundle', summary: 'Import a bundle; sections present replace current ones', auth: 'user', body: { type: 'object', required: ['chomp_bundle'], properties: { chomp_bundle: int } } },
+  { method: 'get', path: '/api/setup', summary: 'First-run checklist (deployment checks; user checks with a token)', auth: 'none' },
+  {
+    method: 'post', path: '/api/setup', summary: 'Configure in one call from a config bundle; creates a token when none is sent', auth: 'none',
+    body: { type: 'object', properties: { keys: { type: 'object', additionalProperties: str }, router_settings: { type: 'object' } } },
+  },
+  { method: 'get', path: '/api/config/callback-secret', summary: 'Secret that signs callback_url deliveries', auth: 'user' },
+  { method: 'post', path: '/api/config/callback-secret', summary: 'Rotate the callback signing secret', auth: 'user' },
+  { method: 'get', path: '/api/config/notifications', summary: 'Notification channels', auth: 'user' },
+  {
+    method: 'put', path: '/api/config/notifications', summary: 'Replace notification channels', auth: 'user',
+    body: {
+      type: 'object',
+      properties: {
+        channels: {
+          type: 'array',
+          items: {
+            type: 'object',
+            required: ['type', 'url'],
+            properties: {
+              type: { type: 'string', enum: ['slack', 'discord', 'webhook'] },
+              url: str,
+              events: { type: 'array', items: { type: 'string', enum: ['job.done', 'job.error', 'router.down', 'report.daily'] } },
+            },
+          },
+        },
+      },
+    },
+  },
+  { method: 'delete', path: '/api/config/notifications', summary: 'Remove all notification channels', auth: 'user' },
+  { method: 'get', path: '/api/models/free', summary: 'Free models on one or all routers', auth: 'none', query: { router: 'Router ID or "all" (default openrouter)' } },
+  { method: 'get', path: '/api/admin/mode', summary: 'Current mode', auth: 'admin' },
+  {
+    method: 'put', path: '/api/admin/mode', summary: 'Switch between normal and read-only', auth: 'admin',
+    body: { type: 'object', required: ['mode'], properties: { mode: { type: 'string', enum: ['normal', 'read-only'] }, message: str } },
+  },
+  { method: 'get', path: '/api/admin/runtime', summary: 'Build, mode, storage health, isolate uptime/requests, instance-wide users and jobs in flight', auth: 'admin' },
+  { method: 'get', path: '/proxy/{router}/{path}', summary: 'Read-through to an allowlisted provider endpoint', auth: 'user' },
+  { method: 'post', path: '/proxy/{router}/{path}', summary: 'Read-through to an allowlisted provider endpoint', auth: 'user' },
+  { method: 'get', path: '/api/openapi.json', summary: 'This document', auth: 'none' },
+  { method: 'get', path: '/api/version', summary: 'Build version and commit; latest GitHub release unless UPDATE_CHECK="false"', auth: 'none' },
+  { method: 'get', path: '/livez', summary: 'Liveness probe', auth: 'none' },
+  { method: 'get', path: '/healthz', summary: 'Liveness probe (alias of /livez)', auth: 'none' },
+  { method: 'get', path: '/readyz', summary: 'Readiness probe: KV round-trip and router table; 503 when not ready', auth: 'none' },
+]
+
+export function buildSpec(origin: string): Schema {
+  const paths: Record<string, Record<string, Schema>> = {}
+  for (const e of endpoints) {
+    const params: Schema[] = [
+      ...[...e.path.matchAll(/\{(\w+)\}/g)].map(([, name]) => ({ name, in: 'path', required: true, schema: str })),
+      ...Object.entries(e.query ?? {}).map(([name, description]) => ({ name, in: 'query', required: false, schema: str, description })),
+    ]
+
+    ;(paths[e.path] ??= {})[e.method] = {
+      summary: e.summary,
+      ...(params.length ? { parameters: params } : {}),
+      ...(e.auth === 'none' ? { security: [] } : e.auth === 'admin' ? { security: [{ admin: [] }] } : {}),
+      ...(e.body ? { requestBody: { required: true, content: { 'application/json': { schema: e.body } } } } : {}),
+      responses: {
+        200: { description: 'OK', content: { 'application/json': { schema: { type: 'object' } } } },
+        default: { description: 'Error', content: { 'application/json': { schema: { $ref: '#/components/schemas/Error' } } } },
+      },
+    }
+  }
+
+  return {
+    openapi: '3.1.0',
+    info: { title: 'chomp', version: '1.0.0', description: 'Free AI model router: OpenAI-compatible proxy plus async dispatch.' },
+    servers: [{ url: origin }],
+    security: [{ bearer: [] }],
+    components: {
+      securitySchemes: {
+        bearer: { type: 'http', scheme: 'bearer', description: 'chomp token from POST /api/keys' },
+        admin: { type: 'http', scheme: 'bearer', description: 'ADMIN_TOKEN secret' },
+      },
+      schemas: { Error: { type: 'object', properties: { error: str } } },
+    },
+    paths,
+  }
+}
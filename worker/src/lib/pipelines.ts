@@ -0,0 +1,60 @@
+/**
+ * Pipelines: a chain of prompts run in order, each step on its own router/model.
+ * Step prompts are templates over earlier output:
+ *
+ * - `{{input}}` — the pipeline's `input`
+ * - `{{prev}}` — the previous step's result (the input, for the first step)
+ * - `{{steps.NAME}}` — the result of an earlier step, by name (steps default to `step1`, `step2`, ...)
+ *
+ * Placeholders are checked before anything runs, so a typo fails the request instead of step 3.
+ */
+
+import { z } from 'zod'
+
+export const MAX_STEPS = 8
+
+const StepSchema = z.object({
+  name: z.string().regex(/^[\w-]{1,32}$/, 'step names are letters, digits, _ and - (max 32)').optional(),
+  prompt: z.string().min(1),
+  system: z.string().optional(),
+  preset: z.string().optional(),
+  router: z.string().optional(),
+  model: z.string().optional(),
+}).strict()
+
+export const PipelineSchema = z.object({
+  input: z.string().default(''),
+  steps: z.array(StepSchema).min(1).max(MAX_STEPS),
+  tags: z.unknown().optional(),
+  metadata: z.unknown().optional(),
+}).strict()
+
+export type PipelineStep = z.infer<typeof StepSchema> & { name: string }
+
+const PLACEHOLDER = /\{\{\s*([\w.-]+)\s*\}\}/g
+
+/** Name every step and check each placeholder refers to the input or an earlier step. */
+export function checkSteps(steps: z.infer<typeof StepSchema>[]): PipelineStep[] | { error: string } {
+  const named = steps.map((s, i) => ({ ...s, name: s.name ?? `step${i + 1}` }))
+  const seen = new Set<string>()
+  for (const step of named) {
+    if (seen.has(step.name)) return { error: `duplicate step name: ${step.name}` }
+    for (const [, ref] of step.prompt.matchAll(PLACEHOLDER)) {
+      if (ref === 'input' || ref === 'prev') continue
+      const earlier = ref.startsWith('steps.') ? ref.slice('steps.'.length) : null
+      if (!earlier || !seen.has(earlier)) {
+        return { error: `step ${step.name}: {{${ref}}} must be input, prev or steps.<earlier step>` }
+      }
+    }
+    seen.add(step.name)
+  }
+  return named
+}
+
+export function renderPrompt(template: string, input: string, results: Record<string, string>, prev: string): string {
+  return template.replace(PLACEHOLDER, (_, ref: string) => {
+    if (ref === 'input') return input
+    if (ref === 'prev') return prev
+    return results[ref.slice('steps.'.length)] ?? ''
+  })
+}
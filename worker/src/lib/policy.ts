@@ -0,0 +1,52 @@
+/**
+ * Model policy: per-user allow/deny rules applied when chomp picks a free model.
+ * Stored in KV as `modelpolicy:{token}`. Patterns are model IDs with `*` wildcards,
+ * e.g. "meta-llama/*" or "*:free".
+ */
+
+import { z } from 'zod'
+
+export const ModelPolicySchema = z.object({
+  allow: z.array(z.string().trim().min(1)).default([]),
+  deny: z.array(z.string().trim().min(1)).default([]),
+  min_context: z.number().int().nonnegative().default(0),
+  notes: z.record(z.string()).default({}),
+}).strict()
+
+export type ModelPolicy = z.infer<typeof ModelPolicySchema>
+
+export const emptyPolicy: ModelPolicy = { allow: [], deny: [], min_context: 0, notes: {} }
+
+export async function getModelPolicy(kv: KVNamespace, token: string): Promise<ModelPolicy> {
+  const raw = await kv.get(`modelpolicy:${token}`)
+  return raw ? { ...emptyPolicy, ...JSON.parse(raw) } : emptyPolicy
+}
+
+export async function saveModelPolicy(kv: KVNamespace, token: string, policy: ModelPolicy): Promise<void> {
+  await kv.put(`modelpolicy:${token}`, JSON.stringify(policy))
+}
+
+export async function deleteModelPolicy(kv: KVNamespace, token: string): Promise<void> {
+  await kv.delete(`modelpolicy:${token}`)
+}
+
+export function matchesPattern(id: string, pattern: string): boolean {
+  const re = new RegExp('^' + pattern.split('*').map(p => p.replace(/[.+?^${}()|[\]\\]/g, '\\$&')).join('.*') + '$', 'i')
+  return re.test(id)
+}
+
+/**
+ * Deny wins over allow; an empty allow list allows everything not denied.
+ * Patterns match the bare model ID or, when a router is given, "router/model".
+ */
+export function allowedByPolicy(
+  policy: ModelPolicy,
+  model: { id: string; router?: string; context_length?: number },
+): boolean {
+  const ids = model.router ? [model.id, `${model.router}/${model.id}`] : [model.id]
+  const matches = (p: string) => ids.some(id => matchesPattern(id, p))
+  if (policy.deny.some(matches)) return false
+  if (policy.allow.length > 0 && !policy.allow.some(matches)) return false
+  if (policy.min_context > 0 && (model.context_length ?? 0) < policy.min_context) return false
+  return true
+}
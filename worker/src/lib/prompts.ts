@@ -0,0 +1,175 @@
+/**
+ * System prompt presets: named, versioned system prompts stored per user in KV as
+ * `prompts:{token}`. Saving a preset appends a version; old versions stay addressable as
+ * `name@N`, so automations that pin a version don't change when the preset is edited.
+ * A bare `name` always means the latest version.
+ *
+ * Also kept here: recently dispatched prompts (`recentprompts:{token}`, deduplicated, newest
+ * first, skipped when the user redacts prompts) and a pinned list (`pinnedprompts:{token}`)
+ * for autocomplete in clients.
+ */
+
+import { z } from 'zod'
+
+export const MAX_PRESETS = 50
+export const MAX_VERSIONS = 50
+
+export const PRESET_NAME_RE = /^[a-z0-9][a-z0-9_-]{0,63}$/
+
+export const PresetInputSchema = z.object({
+  text: z.string().min(1).max(20000),
+  description: z.string().max(200).optional(),
+}).strict()
+
+export interface PresetVersion {
+  version: number
+  text: string
+  created: string
+}
+
+export interface Preset {
+  name: string
+  description: string
+  versions: PresetVersion[]
+}
+
+type Presets = Record<string, Preset>
+
+/** A whole preset document, as exported in config bundles. */
+export const PresetsSchema = z.record(
+  z.string().regex(PRESET_NAME_RE),
+  z.object({
+    name: z.string(),
+    description: z.string().max(200).default(''),
+    versions: z.array(z.object({
+      version: z.number().int().positive(),
+      text: z.string().min(1).max(20000),
+      created: z.string(),
+    }).strict()).min(1).max(MAX_VERSIONS),
+  }).strict(),
+).refine(p => Object.keys(p).length <= MAX_PRESETS, `at most ${MAX_PRESETS} presets`)
+
+export async function getPresets(kv: KVNamespace, token: string): Promise<Presets> {
+  const raw = await kv.get(`prompts:${token}`)
+  return raw ? JSON.parse(raw) : {}
+}
+
+/** Short listing: name, description, latest version number and when it was saved. */
+export function summarizePreset(preset: Preset) {
+  const latest = preset.versions[preset.versions.length - 1]
+  return { name: preset.name, description: preset.description, version: latest.version, updated: latest.created }
+}
+
+/**
+ * Save a new version of a preset (creating it if needed). Unchanged text only updates the
+ * description. Fails once the preset count or a preset's version history is full.
+ */
+export async function savePreset(
+  kv: KVNamespace,
+  token: string,
+  name: string,
+  input: z.infer<typeof PresetInputSchema>,
+): Promise<Preset | { error: string }> {
+  const presets = await getPresets(kv, token)
+  const existing = presets[name]
+  if (!existing && Object.keys(presets).length >= MAX_PRESETS) {
+    return { error: `at most ${MAX_PRESETS} presets` }
+  }
+
+  const preset: Preset = existing ?? { name, description: '', versions: [] }
+  if (input.description !== undefined) preset.description = input.description
+  const latest = preset.versions[preset.versions.length - 1]
+  if (latest?.text !== input.text) {
+    if (preset.versions.length >= MAX_VERSIONS) {
+      return { error: `${name} has ${MAX_VERSIONS} versions; delete it and save it again to start over` }
+    }
+    preset.versions.push({ version: (latest?.version ?? 0) + 1, text: input.text, created: new Date().toISOString() })
+  }
+
+  presets[name] = preset
+  await kv.put(`prompts:${token}`, JSON.stringify(presets))
+  return preset
+}
+
+export async function replacePresets(kv: KVNamespace, token: string, presets: Presets): Promise<void> {
+  await kv.put(`prompts:${token}`, JSON.stringify(presets))
+}
+
+export async function deletePreset(kv: KVNamespace, token: string, name: string): Promise<boolean> {
+  const presets = await getPresets(kv, token)
+  if (!presets[name]) return false
+  delete presets[name]
+  await kv.put(`prompts:${token}`, JSON.stringify(presets))
+  return true
+}
+
+/** Look up `name` (latest) or `name@N`. Returns the text and the exact `name@N` used. */
+export async function resolvePreset(
+  kv: KVNamespace,
+  token: string,
+  ref: unknown,
+): Promise<{ text: string; ref: string } | { error: string }> {
+  if (typeof ref !== 'string') return { error: 'preset must be a string' }
+  const [name, pinned] = ref.split('@', 2)
+  const preset = (await getPresets(kv, token))[name]
+  if (!preset) return { error: `unknown preset: ${name}` }
+
+  const version = pinned === undefined
+    ? preset.versions[preset.versions.length - 1]
+    : preset.versions.find(v => String(v.version) === pinned)
+  if (!version) return { error: `preset ${name} has no version ${pinned}` }
+  return { text: version.text, ref: `${name}@${version.version}` }
+}
+
+export const RECENT_LIMIT = 50
+
+export interface RecentPrompt {
+  prompt: string
+  count: number
+  last_used: string
+}
+
+export async function getRecentPrompts(kv: KVNamespace, token: string): Promise<RecentPrompt[]> {
+  const raw = await kv.get(`recentprompts:${token}`)
+  return raw ? JSON.parse(raw) : []
+}
+
+/** Move a prompt to the front of the recent list (counting repeats), keeping RECENT_LIMIT. */
+export async function recordRecentPrompt(kv: KVNamespace, token: string, prompt: string): Promise<void> {
+  const text = prompt.trim().slice(0, 2000)
+  if (!text) return
+  const recent = await getRecentPrompts(kv, token)
+  const i = recent.findIndex(r => r.prompt === text)
+  const count = i === -1 ? 1 : recent.splice(i, 1)[0].count + 1
+  recent.unshift({ prompt: text, count, last_used: new Date().toISOString() })
+  if (recent.length > RECENT_LIMIT) recent.length = RECENT_LIMIT
+  await kv.put(`recentprompts:${token}`, JSON.stringify(recent))
+}
+
+export async function clearRecentPrompts(kv: KVNamespace, token: string): Promise<void> {
+  await kv.delete(`recentprompts:${token}`)
+}
+
+export const PinnedSchema = z.object({
+  prompts: z.array(z.object({
+    label: z.string().max(64).optional(),
+    prompt: z.string().min(1).max(2000),
+  }).strict()).max(50).default([]),
+}).strict()
+
+export type Pinned = z.infer<typeof PinnedSchema>
+
+export const emptyPinned: Pinned = { prompts: [] }
+
+export async function getPinnedPrompts(kv: KVNamespace, token: string): Promise<Pinned> {
+  const raw = await kv.get(`pinnedprompts:${token}`)
+  return raw ? { ...emptyPinned, ...JSON.parse(raw) } : emptyPinned
+}
+
+export async function savePinnedPrompts(kv: KVNamespace, token: string, pinned: Pinned): Promise<void> {
+  await kv.put(`pinnedprompts:${token}`, JSON.stringify(pinned))
+}
+
+export async function deletePinnedPrompts(kv: KVNamespace, token: string): Promise<void> {
+  await kv.delete(`pinnedprompts:${token}`)
+}
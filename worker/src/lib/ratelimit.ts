@@ -0,0 +1,92 @@
+/**
+ * Upstream rate limits, read from the `x-ratelimit-*` headers providers return on completions
+ * (Groq, Cerebras, OpenAI-style). The latest snapshot per router is kept in KV as
+ * `ratelimit:{token}` so callers can see real remaining quota instead of guessing.
+ */
+
+import type { OpenAIResponse } from './routers'
+
+export interface RateLimit {
+  limit_requests: number | null
+  remaining_requests: number | null
+  reset_requests_s: number | null // seconds until the request window resets
+  limit_tokens: number | null
+  remaining_tokens: number | null
+  reset_tokens_s: number | null
+  retry_after_s: number | null
+  observed: string
+}
+
+/** Parse durations like "2m59.56s", "7.66s", "1h", "120ms" or a bare number of seconds. */
+export function parseDuration(value: string | null): number | null {
+  if (!value) return null
+  if (/^\d+(\.\d+)?$/.test(value.trim())) return Number(value)
+  let seconds = 0
+  let matched = false
+  for (const [, n, unit] of value.matchAll(/(\d+(?:\.\d+)?)(ms|h|m|s)/g)) {
+    matched = true
+    seconds += Number(n) * ({ ms: 0.001, s: 1, m: 60, h: 3600 } as Record<string, number>)[unit]
+  }
+  return matched ? seconds : null
+}
+
+function num(value: string | null): number | null {
+  if (value === null || value.trim() === '') return null
+  const n = Number(value)
+  return Number.isFinite(n) ? n : null
+}
+
+/** Rate-limit snapshot from response headers, or null when the provider sends none. */
+export function parseRateLimit(headers: Headers): RateLimit | null {
+  const rl: RateLimit = {
+    limit_requests: num(headers.get('x-ratelimit-limit-requests')),
+    remaining_requests: num(headers.get('x-ratelimit-remaining-requests')),
+    reset_requests_s: parseDuration(headers.get('x-ratelimit-reset-requests')),
+    limit_tokens: num(headers.get('x-ratelimit-limit-tokens')),
+    remaining_tokens: num(headers.get('x-ratelimit-remaining-tokens')),
+    reset_tokens_s: parseDuration(headers.get('x-ratelimit-reset-tokens')),
+    retry_after_s: parseDuration(headers.get('retry-after')),
+    observed: new Date().toISOString(),
+  }
+  const { observed: _o, ...values } = rl
+  return Object.values(values).some(v => v !== null) ? rl : null
+}
+
+export async function getRateLimits(kv: KVNamespace, token: string): Promise<Record<string, RateLimit>> {
+  const raw = await kv.get(`ratelimit:${token}`)
+  return raw ? JSON.parse(raw) : {}
+}
+
+export async function recordRateLimit(kv: KVNamespace, token: string, routerId: string, rl: RateLimit): Promise<void> {
+  const limits = await getRateLimits(kv, token)
+  limits[routerId] = rl
+  await kv.put(`ratelimit:${token}`, JSON.stringify(limits))
+}
+
+/** Whether an upstream response is a rate-limit rejection (see classifyError). */
+export function isRateLimited(res: OpenAIResponse): boolean {
+  return res.error?.class === 'rate_limit'
+}
+
+const DEFAULT_RETRY_MS = 2000
+
+/** How long to wait before retrying, from Retry-After or the nearest window reset. */
+export function retryDelayMs(rl?: RateLimit): number {
+  const s = rl?.retry_after_s ?? rl?.reset_requests_s ?? rl?.reset_tokens_s
+  return s !== null && s !== undefined ? Math.max(Math.ceil(s * 1000), 100) : DEFAULT_RETRY_MS
+}
+
+export const DEFAULT_QUEUE_MAX_WAIT = 60
+
+/**
+ * The caller's patience for rate-limited upstreams: `X-Max-Wait` in seconds, capped by the
+ * QUEUE_MAX_WAIT var. 0 (the default) passes rate-limit errors straight through.
+ */
+export function maxWaitMs(request: Request, cap: string | undefined): number {
+  const requested = Number(request.headers.get('X-Max-Wait'))
+  if (!Number.isFinite(requested) || requested <= 0) return 0
+  const limit = Number(cap) > 0 ? Number(cap) : DEFAULT_QUEUE_MAX_WAIT
+  return Math.min(requested, limit) * 1000
+}
+
+export const sleep = (ms: number) => new Promise<void>(resolve => setTimeout(resolve, ms))
@@ -0,0 +1,67 @@
+/**
+ * Redaction: per-user control over how much prompt and completion text is kept in job
+ * storage. Stored in KV as `redaction:{token}`. Applied when jobs are written, so the raw
+ * text only ever lives in memory for the duration of the call.
+ *
+ * Modes: `none` (default), `truncate` (first `truncate_chars` chars), `hash` (sha256), `drop`.
+ */
+
+import { z } from 'zod'
+
+const Mode = z.enum(['none', 'truncate', 'hash', 'drop'])
+export type RedactionMode = z.infer<typeof Mode>
+
+export const RedactionSchema = z.object({
+  prompts: Mode.default('none'), // prompt + system
+  results: Mode.default('none'),
+  truncate_chars: z.number().int().min(1).max(10_000).default(200),
+}).strict()
+
+export type Redaction = z.infer<typeof RedactionSchema>
+
+export const defaultRedaction: Redaction = { prompts: 'none', results: 'none', truncate_chars: 200 }
+
+export async function getRedaction(kv: KVNamespace, token: string): Promise<Redaction> {
+  const raw = await kv.get(`redaction:${token}`)
+  return raw ? { ...defaultRedaction, ...JSON.parse(raw) } : defaultRedaction
+}
+
+export async function saveRedaction(kv: KVNamespace, token: string, redaction: Redaction): Promise<void> {
+  await kv.put(`redaction:${token}`, JSON.stringify(redaction))
+}
+
+export async function deleteRedaction(kv: KVNamespace, token: string): Promise<void> {
+  await kv.delete(`redaction:${token}`)
+}
+
+export async function redactText(text: string, mode: RedactionMode, chars: number): Promise<string> {
+  if (!text || mode === 'none') return text
+  if (mode === 'drop') return '[redacted]'
+  if (mode === 'truncate') return text.length > chars ? `${text.slice(0, chars)}… [truncated ${text.length - chars} chars]` : text
+  const digest = await crypto.subtle.digest('SHA-256', new TextEncoder().encode(text))
+  return 'sha256:' + Array.from(new Uint8Array(digest)).map(b => b.toString(16).padStart(2, '0')).join('')
+}
+
+type Redactable = {
+  prompt?: string
+  system?: string
+  result?: string
+  input?: string
+  results?: Array<{ result: string }>
+  steps?: Array<{ prompt: string; result: string }>
+}
+
+/** Copy of a job with prompt/system and result text redacted per the user's settings. */
+export async function redactJob<T extends Redactable>(job: T, r: Redaction): Promise<T> {
+  if (r.prompts === 'none' && r.results === 'none') return job
+  const out: T = { ...job }
+  const p = (t: string) => redactText(t, r.prompts, r.truncate_chars)
+  const res = (t: string) => redactText(t, r.results, r.truncate_chars)
+  if (out.prompt !== undefined) out.prompt = await p(out.prompt)
+  if (out.system !== undefined) out.system = await p(out.system)
+  if (out.result !== undefined) out.result = await res(out.result)
+  if (out.input !== undefined) out.input = await p(out.input)
+  if (out.steps) out.steps = await Promise.all(out.steps.map(async x => ({ ...x, prompt: await p(x.prompt), result: await res(x.result) })))
+  if (out.results) out.results = await Promise.all(out.results.map(async x => ({ ...x, result: await res(x.result) })))
+  return out
+}
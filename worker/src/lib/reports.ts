@@ -0,0 +1,107 @@
+/**
+ * Daily reports: usage totals, per-router breakdown and notable job errors for one UTC day,
+ * plus a short natural-language digest written by a free model. Cached in KV as
+ * `report:{token}:{YYYY-MM-DD}` for an hour so repeated reads don't spend tokens.
+ */
+
+import type { UserRecord } from './auth'
+import type { ModelPolicy } from './policy'
+import { resolveTarget, isTargetError, complete } from './dispatch'
+import { getStatsBucket, summarize, recordCompletion } from './stats'
+import { getJob } from './jobs'
+import type { Job } from './jobs'
+
+const REPORT_TTL = 3600
+const MAX_ERRORS = 5
+
+const DIGEST_SYSTEM =
+  'You write short daily usage digests for an AI model router. Given JSON stats, reply with 2-4 plain sentences: ' +
+  'volume, which routers carried the load, failure rate, and anything that needs attention. No preamble, no markdown.'
+
+export interface DailyReport {
+  date: string
+  totals: ReturnType<typeof summarize>
+  by_router: Record<string, ReturnType<typeof summarize>>
+  by_source: Record<string, ReturnType<typeof summarize>>
+  errors: Array<{ message: string; count: number; router: string }>
+  digest: string
+  digest_model: string
+  generated: string
+}
+
+/** Distinct job errors for the day, most frequent first. Jobs expire after 24h, so older days have none. */
+async function notableErrors(kv: KVNamespace, token: string, date: string): Promise<DailyReport['errors']> {
+  const raw = await kv.get(`jobindex:${token}`)
+  const ids: string[] = raw ? JSON.parse(raw) : []
+  const jobs = await Promise.all(ids.map(id => getJob<Job>(kv, token, id)))
+
+  const counts = new Map<string, { message: string; count: number; router: string }>()
+  for (const job of jobs) {
+    if (!job || job.status !== 'error' || !job.created.startsWith(date) || !job.error) continue
+    const message = job.error.slice(0, 200)
+    const entry = counts.get(message) ?? { message, count: 0, router: job.router }
+    entry.count++
+    counts.set(message, entry)
+  }
+  return [...counts.values()].sort((a, b) => b.count - a.count).slice(0, MAX_ERRORS)
+}
+
+export async function getDailyReport(params: {
+  kv: KVNamespace
+  token: string
+  user: UserRecord
+  policy: ModelPolicy
+  date: string
+  refresh?: boolean
+}): Promise<DailyReport> {
+  const { kv, token, user, policy, date } = params
+  const key = `report:${token}:${date}`
+  if (!params.refresh) {
+    const cached = await kv.get(key)
+    if (cached) return JSON.parse(cached) as DailyReport
+  }
+
+  const bucket = await getStatsBucket(kv, token, date)
+
+  const report: DailyReport = {
+    date,
+    totals: summarize([bucket]),
+    by_router: Object.fromEntries(Object.entries(bucket.by_router).map(([k, c]) => [k, summarize([c])])),
+    by_source: Object.fromEntries(Object.entries(bucket.by_source).map(([k, c]) => [k, summarize([c])])),
+    errors: await notableErrors(kv, token, date),
+    digest: '',
+    digest_model: '',
+    generated: new Date().toISOString(),
+  }
+
+  if (report.totals.requests === 0) {
+    report.digest = `No requests on ${date}.`
+  } else {
+    const target = await resolveTarget(user, { model: 'auto' }, policy)
+    if (isTargetError(target)) {
+      report.digest = `(no digest: ${target.error})`
+    } else {
+      const { digest: _d, digest_model: _m, ...facts } = report
+      const out = await complete(target, JSON.stringify(facts), DIGEST_SYSTEM)
+      report.digest = out.status === 'done' ? out.result.trim() : `(no digest: ${out.error})`
+      report.digest_model = `${target.router.id}/${target.model}`
+      await recordCompletion(kv, token, target, out, 'report')
+    }
+  }
+
+  await kv.put(key, JSON.stringify(report), { expirationTtl: REPORT_TTL })
+  return report
+}
+
+/** Plain-text rendering for notification channels. */
+export function formatReport(r: DailyReport): string {
+  const t = r.totals
+  const lines = [
+    `chomp daily report — ${r.date}`,
+    `${t.requests} requests, ${t.errors} failed (${Math.round(t.failure_rate * 100)}%), ${t.tokens_in + t.tokens_out} tokens, avg ${t.avg_latency_ms}ms`,
+    ...Object.entries(r.by_router).map(([id, c]) => `• ${id}: ${c.requests} requests, ${c.tokens_in + c.tokens_out} tokens`),
+    ...r.errors.map(e => `! ${e.router}: ${e.message} (×${e.count})`),
+  ]
+  if (r.digest) lines.push('', r.digest)
+  return lines.join('\n')
+}
@@ -1,13 +1,61 @@
 // Shared router infrastructure for OpenAI-compatible API providers
 
+import type { RateLimit } from "./ratelimit"
+import { parseRateLimit } from "./ratelimit"
+import type { ErrorClass } from "./errors"
+import { classifyError, cleanErrorText } from "./errors"
+
+export interface RouterSetting {
+  id: string
+  label: string
+}
+
 export interface RouterDef {
   id: string
   name: string
+  /** May contain `{setting}` placeholders filled from the user's router settings. */
   baseUrl: string
   defaultModel: string
   headers?: Record<string, string>
+  /** Values required alongside the API key (e.g. an account ID). */
+  settings?: readonly RouterSetting[]
+  /** Extra "METHOD path" patterns reachable via /proxy/{id}/..., on top of DEFAULT_PROXY_PATHS. */
+  proxyPaths?: readonly string[]
+  /** Upstream timeout for completions; DEFAULT_TIMEOUT_MS when unset. */
+  timeoutMs?: number
 }
 
+export const DEFAULT_TIMEOUT_MS = 120_000
+export const MAX_TIMEOUT_MS = 300_000
+
+/** Standard OpenAI chat parameters forwarded to the upstream as-is. */
+export const PASSTHROUGH_PARAMS = [
+  "max_tokens",
+  "max_completion_tokens",
+  "temperature",
+  "top_p",
+  "stop",
+  "seed",
+  "presence_penalty",
+  "frequency_penalty",
+  "logit_bias",
+  "logprobs",
+  "top_logprobs",
+  "response_format",
+  "tools",
+  "tool_choice",
+  "parallel_tool_calls",
+  "user",
+] as const
+
+/** The passthrough parameters present in a request body. */
+export function pickParams(body: Record<string, unknown>): Record<string, unknown> {
+  return Object.fromEntries(PASSTHROUGH_PARAMS.filter((k) => body[k] !== undefined).map((k) => [k, body[k]]))
+}
+
+/** Provider-native paths every router exposes through /proxy. `*` matches one path segment. */
+export const DEFAULT_PROXY_PATHS: readonly string[] = ["GET models", "GET models/*"]
+
 export const routers: readonly RouterDef[] = [
   {
     id: "zen",
@@ -48,6 +96,34 @@ export const routers: readonly RouterDef[] = [
       "HTTP-Referer": "https://chomp.coey.dev",
       "X-Title": "chomp",
     },
+    proxyPaths: ["GET generation", "GET key", "GET credits", "GET auth/key"],
+  },
+  {
+    id: "cloudflare",
+    name: "Cloudflare Workers AI",
+    baseUrl: "https://api.cloudflare.com/client/v4/accounts/{account_id}/ai/v1",
+    defaultModel: "@cf/meta/llama-3.3-70b-instruct-fp8-fast",
+    settings: [{ id: "account_id", label: "Account ID" }],
+  },
+  {
+    id: "mistral",
+    name: "Mistral",
+    baseUrl: "https://api.mistral.ai/v1",
+    defaultModel: "mistral-small-latest",
+  },
+  {
+    id: "deepseek",
+    name: "DeepSeek",
+    baseUrl: "https://api.deepseek.com/v1",
+    defaultModel: "deepseek-chat",
+    // deepseek-reasoner can think for minutes before answering
+    timeoutMs: 300_000,
+  },
+  {
+    id: "xai",
+    name: "xAI",
+    baseUrl: "https://api.x.ai/v1",
+    defaultModel: "grok-3-mini",
   },
 ] as const
 
@@ -55,6 +131,27 @@ export function getRouter(id: string): RouterDef | undefined {
   return routers.find((r) => r.id === id)
 }
 
+/** Whether a method + path (relative to baseUrl, no leading slash) may be proxied for this router. */
+export function isProxyAllowed(router: RouterDef, method: string, path: string): boolean {
+  const segments = path.split("/")
+  return [...DEFAULT_PROXY_PATHS, ...(router.proxyPaths ?? [])].some((entry) => {
+    const [m, pattern] = entry.split(" ")
+    if (m !== method) return false
+    const parts = pattern.split("/")
+    return parts.length === segments.length && parts.every((p, i) => p === "*" || p === segments[i])
+  })
+}
+
+/** Return the IDs of required settings that are missing or blank. */
+export function missingSettings(router: RouterDef, settings: Record<string, string> = {}): string[] {
+  return (router.settings ?? []).map((f) => f.id).filter((id) => !settings[id]?.trim())
+}
+
+/** Fill `{setting}` placeholders in the router's base URL. */
+export function resolveBaseUrl(router: RouterDef, settings: Record<string, string> = {}): string {
+  return router.baseUrl.replace(/\{(\w+)\}/g, (_, id: string) => encodeURIComponent(settings[id] ?? ""))
+}
+
 export function resolveRouterAndModel(input: string): {
   router: string | undefined
   model: string
@@ -93,6 +190,9 @@ export interface OpenAIResponse {
     message: string
     type?: string
     code?: string | number | null
+    /** Added by chomp: upstream HTTP status and error class. */
+    status?: number
+    class?: ErrorClass
   }
 }
 
@@ -101,9 +201,14 @@ export async function callRouter(params: {
   apiKey: string
   model: string
   messages: Array<{ role: string; content: string }>
+  /** Extra OpenAI parameters (see PASSTHROUGH_PARAMS). */
+  params?: Record<string, unknown>
+  settings?: Record<string, string>
   signal?: AbortSignal
+  /** Called with the upstream rate-limit headers, when the provider sends any. */
+  onRateLimit?: (rl: RateLimit) => void
 }): Promise<OpenAIResponse> {
-  const { router, apiKey, model, messages, signal } = params
+  const { router, apiKey, model, messages, settings, signal, onRateLimit } = params
 
   const headers: Record<string, string> = {
     "Content-Type": "application/json",
@@ -111,13 +216,16 @@ export async function callRouter(params: {
     ...router.headers,
   }
 
-  const response = await fetch(`${router.baseUrl}/chat/completions`, {
+  const response = await fetch(`${resolveBaseUrl(router, settings)}/chat/completions`, {
     method: "POST",
     headers,
-    body: JSON.stringify({ model, messages }),
+    body: JSON.stringify({ ...params.params, model, messages }),
     signal,
   })
 
+  const rateLimit = parseRateLimit(response.headers)
+  if (rateLimit) onRateLimit?.(rateLimit)
+
   if (!response.ok) {
     const text = await response.text().catch(() => "")
     let parsed: OpenAIResponse | undefined
@@ -126,22 +234,98 @@ export async function callRouter(params: {
     } catch {
       // not JSON
     }
-    if (parsed?.error) {
-      return parsed
-    }
+    const error = parsed?.error
+      ? { ...parsed.error, status: response.status }
+      : {
+          message: cleanErrorText(text) || `HTTP ${response.status} ${response.statusText}`,
+          type: "api_error",
+          code: response.status,
+          status: response.status,
+        }
     return {
-      id: "",
-      object: "error",
-      created: 0,
-      model,
-      choices: [],
-      error: {
-        message: text || `HTTP ${response.status} ${response.statusText}`,
-        type: "api_error",
-        code: response.status,
-      },
+      ...(parsed ?? { id: "", object: "error", created: 0, model, choices: [] }),
+      error: { ...error, class: classifyError(error) },
     }
   }
 
-  return (await response.json()) as OpenAIResponse
+  // Some providers report failures inside a 200 response
+  const data = (await response.json()) as OpenAIResponse
+  if (data.error) data.error.class = classifyError(data.error)
+  return data
+}
+
+export interface ConnectionCheck {
+  ok: boolean
+  latency_ms: number
+  error: string
+}
+
+export interface ConnectionTest {
+  ok: boolean
+  model: string
+  models: ConnectionCheck
+  completion: ConnectionCheck
+  tested: string
+}
+
+async function timedFetch(url: string, init: RequestInit): Promise<ConnectionCheck & { body: string }> {
+  const start = Date.now()
+  try {
+    const response = await fetch(url, init)
+    const body = await response.text().catch(() => "")
+    const latency_ms = Date.now() - start
+    if (!response.ok) {
+      return { ok: false, latency_ms, error: body || `HTTP ${response.status} ${response.statusText}`, body }
+    }
+    return { ok: true, latency_ms, error: "", body }
+  } catch (e) {
+    return { ok: false, latency_ms: Date.now() - start, error: (e as Error).message, body: "" }
+  }
+}
+
+/**
+ * Real minimal calls to check that a key and its settings work: list models, then a
+ * 1-token completion. Upstream errors are returned verbatim.
+ */
+export async function testConnection(params: {
+  router: RouterDef
+  apiKey: string
+  settings?: Record<string, string>
+  model?: string
+}): Promise<ConnectionTest> {
+  const { router, apiKey, settings } = params
+  const baseUrl = resolveBaseUrl(router, settings)
+  const headers: Record<string, string> = {
+    "Content-Type": "application/json",
+    Authorization: `Bearer ${apiKey}`,
+    ...router.headers,
+  }
+
+  const { body: listing, ...models } = await timedFetch(`${baseUrl}/models`, { headers })
+
+  // "auto" is chomp's alias, not an upstream model — probe with a listed free model instead
+  let model = params.model || router.defaultModel
+  if (model === "auto") {
+    let ids: string[] = []
+    try {
+      ids = ((JSON.parse(listing) as { data?: { id: string }[] }).data ?? []).map((m) => m.id)
+    } catch {
+      // listing failed or wasn't JSON
+    }
+    model = ids.find((id) => id.endsWith(":free")) ?? ids[0] ?? model
+  }
+
+  const { body: _, ...completion } = await timedFetch(`${baseUrl}/chat/completions`, {
+    method: "POST",
+    headers,
+    body: JSON.stringify({ model, messages: [{ role: "user", content: "ping" }], max_tokens: 1 }),
+  })
+
+  return {
+    ok: models.ok && completion.ok,
+    model,
+    models,
+    completion,
+    tested: new Date().toISOString(),
+  }
 }
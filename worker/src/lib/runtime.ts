@@ -0,0 +1,55 @@
+/**
+ * Runtime diagnostics for /api/admin/runtime. Workers expose no heap or thread stats, so this
+ * reports what can be observed: this isolate's age and request count, plus instance-wide
+ * counts read from KV (users, users with background jobs in flight, their slots).
+ */
+
+import { inflightSlots } from './inflight'
+
+const isolate = { started: Date.now(), requests: 0 }
+
+/** Count a request against this isolate (called from the middleware). */
+export function trackRequest(): void {
+  isolate.requests++
+}
+
+export function isolateStats() {
+  return {
+    started: new Date(isolate.started).toISOString(),
+    uptime_s: Math.round((Date.now() - isolate.started) / 1000),
+    requests: isolate.requests,
+  }
+}
+
+const MAX_LIST_PAGES = 10 // 10k keys
+const MAX_INFLIGHT_READS = 100
+
+/** Keys under a prefix, up to MAX_LIST_PAGES pages; `truncated` when there were more. */
+async function listKeys(kv: KVNamespace, prefix: string): Promise<{ names: string[]; truncated: boolean }> {
+  const names: string[] = []
+  let cursor: string | undefined
+  for (let page = 0; page < MAX_LIST_PAGES; page++) {
+    const res = await kv.list({ prefix, cursor })
+    names.push(...res.keys.map(k => k.name))
+    if (res.list_complete) return { names, truncated: false }
+    cursor = res.cursor
+  }
+  return { names, truncated: true }
+}
+
+export async function instanceStats(kv: KVNamespace) {
+  const [users, inflight] = await Promise.all([listKeys(kv, 'user:'), listKeys(kv, 'inflight:')])
+  const tokens = inflight.names.slice(0, MAX_INFLIGHT_READS).map(n => n.slice('inflight:'.length))
+  const perUser = await Promise.all(tokens.map(t => inflightSlots(kv, t)))
+  const busy = perUser.filter(u => u.jobs > 0)
+  return {
+    users: users.names.length,
+    users_truncated: users.truncated,
+    inflight: {
+      users: busy.length,
+      jobs: busy.reduce((n, u) => n + u.jobs, 0),
+      slots: busy.reduce((n, u) => n + u.slots, 0),
+      truncated: inflight.truncated || inflight.names.length > MAX_INFLIGHT_READS,
+    },
+  }
+}
@@ -0,0 +1,95 @@
+/**
+ * Scoped tokens: short-lived child tokens a user mints for one job or agent run. They only
+ * work on /v1 (chat completions and models), act as the parent user, and attribute their
+ * usage to the token's `label` as if sent with `X-Chomp-Client`. A leaked scoped token
+ * expires on its own and can't touch keys or config.
+ *
+ * Stored in KV as `scoped:{token}` (expiring with the token); the parent's list of children
+ * is `scopedtokens:{parent}`.
+ */
+
+import { z } from 'zod'
+
+export const SCOPED_PREFIX = 'chs_'
+export const DEFAULT_SCOPED_TTL = 3600
+export const MAX_SCOPED_TTL = 7 * 86400
+const MIN_SCOPED_TTL = 60 // KV's minimum expiration
+const MAX_SCOPED_TOKENS = 50
+
+export const ScopedTokenSchema = z.object({
+  label: z.string().regex(/^[\w.:@-]{1,64}$/, 'label must be letters, digits, ._:@- (max 64)'),
+  ttl_s: z.number().int().min(MIN_SCOPED_TTL).max(MAX_SCOPED_TTL).default(DEFAULT_SCOPED_TTL),
+}).strict()
+
+export interface ScopedToken {
+  id: string // first chars of the token, safe to display and used to revoke
+  parent: string
+  label: string
+  created: string
+  expires: string
+}
+
+export function isScopedToken(token: string): boolean {
+  return token.startsWith(SCOPED_PREFIX)
+}
+
+/** The live scoped token record, or null when unknown, revoked or expired. */
+export async function resolveScoped(token: string, kv: KVNamespace): Promise<ScopedToken | null> {
+  if (!isScopedToken(token)) return null
+  const raw = await kv.get(`scoped:${token}`)
+  if (!raw) return null
+  const record = JSON.parse(raw) as ScopedToken
+  return Date.parse(record.expires) > Date.now() ? record : null
+}
+
+async function getChildren(kv: KVNamespace, parent: string): Promise<string[]> {
+  const raw = await kv.get(`scopedtokens:${parent}`)
+  return raw ? JSON.parse(raw) : []
+}
+
+/** The parent's live scoped tokens (expired ones are pruned from the list as a side effect). */
+export async function listScoped(kv: KVNamespace, parent: string): Promise<ScopedToken[]> {
+  const children = await getChildren(kv, parent)
+  const records = await Promise.all(children.map(t => resolveScoped(t, kv)))
+  const live = children.filter((_, i) => records[i])
+  if (live.length !== children.length) await kv.put(`scopedtokens:${parent}`, JSON.stringify(live))
+  return records.filter((r): r is ScopedToken => r !== null)
+}
+
+export async function mintScoped(
+  kv: KVNamespace,
+  parent: string,
+  input: z.infer<typeof ScopedTokenSchema>,
+): Promise<{ token: string; record: ScopedToken } | { error: string }> {
+  const live = await listScoped(kv, parent)
+  if (live.length >= MAX_SCOPED_TOKENS) return { error: `at most ${MAX_SCOPED_TOKENS} live scoped tokens` }
+
+  const bytes = crypto.getRandomValues(new Uint8Array(24))
+  const token = SCOPED_PREFIX + Array.from(bytes, b => b.toString(16).padStart(2, '0')).join('')
+  const now = Date.now()
+  const record: ScopedToken = {
+    id: token.slice(0, SCOPED_PREFIX.length + 8),
+    parent,
+    label: input.label,
+    created: new Date(now).toISOString(),
+    expires: new Date(now + input.ttl_s * 1000).toISOString(),
+  }
+  await kv.put(`scoped:${token}`, JSON.stringify(record), { expirationTtl: input.ttl_s })
+  await kv.put(`scopedtokens:${parent}`, JSON.stringify([...(await getChildren(kv, parent)), token]))
+  return { token, record }
+}
+
+/** Revoke one of the parent's scoped tokens by id. */
+export async function revokeScoped(kv: KVNamespace, parent: string, id: string): Promise<boolean> {
+  const children = await getChildren(kv, parent)
+  const token = children.find(t => t.startsWith(id) && id.length >= SCOPED_PREFIX.length + 8)
+  if (!token) return false
+  await kv.delete(`scoped:${token}`)
+  await kv.put(`scopedtokens:${parent}`, JSON.stringify(children.filter(t => t !== token)))
+  return true
+}
+
+/** Drop the parent from listings (the record never leaves the server). */
+export function describeScoped({ parent: _parent, ...rest }: ScopedToken) {
+  return rest
+}
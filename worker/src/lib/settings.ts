@@ -0,0 +1,110 @@
+// Per-router settings documents: API key plus any extra fields the router needs
+
+import { z } from 'zod'
+import type { UserRecord } from './auth'
+import type { RouterDef, ConnectionTest } from './routers'
+import type { RateLimit } from './ratelimit'
+
+/** Build the validation schema for one router's settings document. */
+export function routerSettingsSchema(router: RouterDef) {
+  const fields = Object.fromEntries(
+    (router.settings ?? []).map(f => [f.id, z.string().trim().min(1, `${f.label} required`)]),
+  )
+  return z.object({
+    key: z.string().trim().min(1, 'key required'),
+    settings: router.settings?.length ? z.object(fields).strict() : z.object({}).strict().default({}),
+    test: z.boolean().optional(),
+  }).strict()
+}
+
+export type RouterSettingsInput = z.infer<ReturnType<typeof routerSettingsSchema>>
+
+/** Flatten zod issues into one readable message, e.g. "settings.account_id: Account ID required". */
+export function formatIssues(error: z.ZodError): string {
+  return error.issues
+    .map(i => (i.path.length ? `${i.path.join('.')}: ${i.message}` : i.message))
+    .join('; ')
+}
+
+/** Last connection test per router, stored in KV as `routertest:{token}`. */
+export async function getRouterTests(kv: KVNamespace, token: string): Promise<Record<string, ConnectionTest>> {
+  const raw = await kv.get(`routertest:${token}`)
+  return raw ? JSON.parse(raw) : {}
+}
+
+export async function recordRouterTest(
+  kv: KVNamespace,
+  token: string,
+  routerId: string,
+  test: ConnectionTest,
+): Promise<void> {
+  const tests = await getRouterTests(kv, token)
+  tests[routerId] = test
+  await kv.put(`routertest:${token}`, JSON.stringify(tests))
+}
+
+export interface KeyRotation {
+  router: string
+  from: string // key previews only
+  to: string
+  rotated: string
+}
+
+const ROTATION_LOG_LIMIT = 50
+
+/** Key rotation history, newest first, stored in KV as `keyrotations:{token}`. */
+export async function getKeyRotations(kv: KVNamespace, token: string): Promise<KeyRotation[]> {
+  const raw = await kv.get(`keyrotations:${token}`)
+  return raw ? JSON.parse(raw) : []
+}
+
+export async function recordKeyRotation(kv: KVNamespace, token: string, rotation: KeyRotation): Promise<void> {
+  const log = await getKeyRotations(kv, token)
+  log.unshift(rotation)
+  if (log.length > ROTATION_LOG_LIMIT) log.length = ROTATION_LOG_LIMIT
+  await kv.put(`keyrotations:${token}`, JSON.stringify(log))
+}
+
+/** Describe a router's fields and the user's current configuration state. */
+export function describeRouter(router: RouterDef, user: UserRecord, lastTest?: ConnectionTest, rateLimit?: RateLimit) {
+  const settings = user.settings?.[router.id] ?? {}
+  const fields = [
+    { id: 'key', label: 'API key', secret: true },
+    ...(router.settings ?? []).map(f => ({ id: f.id, label: f.label, secret: false })),
+  ]
+  const missing = fields
+    .filter(f => (f.id === 'key' ? !user.keys[router.id] : !settings[f.id]))
+    .map(f => f.id)
+  return {
+    id: router.id,
+    name: router.name,
+    fields,
+    configured: missing.length === 0,
+    missing,
+    settings,
+    last_test: lastTest ?? null,
+    rate_limit: rateLimit ?? null,
+  }
+}
+
+/** Atomically replace a router's key and settings on the user record (in memory). */
+export function applyRouterSettings(user: UserRecord, routerId: string, input: RouterSettingsInput): void {
+  user.keys = { ...user.keys, [routerId]: input.key }
+  const settings = { ...(user.settings ?? {}) }
+  if (Object.keys(input.settings).length > 0) {
+    settings[routerId] = input.settings as Record<string, string>
+  } else {
+    delete settings[routerId]
+  }
+  user.settings = settings
+}
+
+/** Remove a router's key and settings together (in memory). */
+export function clearRouterSettings(user: UserRecord, routerId: string): void {
+  const { [routerId]: _key, ...keys } = user.keys
+  user.keys = keys
+  if (user.settings) {
+    const { [routerId]: _settings, ...settings } = user.settings
+    user.settings = settings
+  }
+}
@@ -0,0 +1,79 @@
+/**
+ * First-run checklist: what a deployment (and, with a token, a user) still needs before
+ * requests can succeed. Each check is `required` (requests fail without it) or advisory.
+ */
+
+import type { UserRecord } from './auth'
+import { routers, missingSettings } from './routers'
+import { getRouterTests } from './settings'
+import { readiness } from './health'
+
+export interface SetupCheck {
+  id: string
+  ok: boolean
+  required: boolean
+  detail: string
+}
+
+export async function setupChecks(env: Env, auth: { token: string; user: UserRecord } | null): Promise<SetupCheck[]> {
+  const ready = await readiness(env)
+  const checks: SetupCheck[] = [
+    {
+      id: 'storage',
+      ok: ready.checks.kv.ok,
+      required: true,
+      detail: ready.checks.kv.ok ? 'KV namespace JOBS is writable' : `KV check failed: ${ready.checks.kv.detail}`,
+    },
+    {
+      id: 'admin_token',
+      ok: Boolean(env.ADMIN_TOKEN),
+      required: false,
+      detail: env.ADMIN_TOKEN ? 'ADMIN_TOKEN is set' : 'ADMIN_TOKEN is not set; /api/admin/* is disabled (wrangler secret put ADMIN_TOKEN)',
+    },
+  ]
+
+  if (!auth) {
+    checks.push({
+      id: 'token',
+      ok: false,
+      required: true,
+      detail: 'no chomp token: POST /api/setup with { keys: { router: key } } (or POST /api/keys) to create one',
+    })
+    return checks
+  }
+
+  const { token, user } = auth
+  const configured = routers.filter(r => user.keys[r.id])
+  const incomplete = configured.filter(r => missingSettings(r, user.settings?.[r.id]).length > 0)
+  const usable = configured.filter(r => !incomplete.includes(r))
+  checks.push({
+    id: 'routers',
+    ok: usable.length > 0,
+    required: true,
+    detail: usable.length > 0
+      ? `usable: ${usable.map(r => r.id).join(', ')}`
+      : 'no router has a key; PUT /api/config/routers/{id} to add one',
+  })
+  if (incomplete.length > 0) {
+    checks.push({
+      id: 'router_settings',
+      ok: false,
+      required: false,
+      detail: incomplete.map(r => `${r.id} is missing ${missingSettings(r, user.settings?.[r.id]).join(', ')}`).join('; '),
+    })
+  }
+
+  const tests = await getRouterTests(env.JOBS, token)
+  const untested = usable.filter(r => !tests[r.id])
+  const failing = usable.filter(r => tests[r.id] && !tests[r.id].ok)
+  checks.push({
+    id: 'router_tests',
+    ok: untested.length === 0 && failing.length === 0,
+    required: false,
+    detail: [
+      failing.length ? `last test failed: ${failing.map(r => r.id).join(', ')}` : '',
+      untested.length ? `never tested: ${untested.map(r => r.id).join(', ')} (POST /api/config/routers/{id}/test)` : '',
+    ].filter(Boolean).join('; ') || 'every usable router passed its last test',
+  })
+  return checks
+}
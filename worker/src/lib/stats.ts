@@ -0,0 +1,140 @@
+/**
+ * Usage stats: one bucket per user per UTC day, stored in KV as `stats:{token}:{YYYY-MM-DD}`
+ * (90-day TTL). Buckets are read-modify-write, so concurrent completions may occasionally
+ * drop an increment — fine for dashboards, not for billing.
+ *
+ * Callers sharing one token can label themselves with an `X-Chomp-Client` header;
+ * usage is then also broken down per client (and per router within each client).
+ */
+
+import type { Target, Completion } from './dispatch'
+import { findModelInfo } from './models'
+import { recordRateLimit } from './ratelimit'
+import { recordLatency } from './latency'
+
+export const STATS_TTL = 90 * 86400
+
+export interface Counters {
+  requests: number
+  done: number
+  errors: number
+  tokens_in: number
+  tokens_out: number
+  latency_ms: number // summed; divide by requests for the average
+  cost_usd: number // from upstream pricing where known
+}
+
+export interface ClientCounters extends Counters {
+  by_router: Record<string, Counters>
+}
+
+export interface StatsBucket extends Counters {
+  by_router: Record<string, Counters>
+  by_source: Record<string, Counters>
+  by_client?: Record<string, ClientCounters> // absent on buckets written before client labels
+}
+
+export type UsageSource = 'dispatch' | 'fanout' | 'best' | 'pipeline' | 'v1' | 'mcp' | 'report'
+
+const emptyCounters = (): Counters => ({
+  requests: 0, done: 0, errors: 0, tokens_in: 0, tokens_out: 0, latency_ms: 0, cost_usd: 0,
+})
+
+const emptyBucket = (): StatsBucket => ({ ...emptyCounters(), by_router: {}, by_source: {}, by_client: {} })
+
+export const UNLABELED_CLIENT = 'unlabeled'
+
+/** The caller's `X-Chomp-Client` label (letters, digits, `._:@-`, max 64 chars). */
+export function clientLabel(request: Request): string {
+  const label = request.headers.get('X-Chomp-Client')?.trim() ?? ''
+  return /^[\w.:@-]{1,64}$/.test(label) ? label : UNLABELED_CLIENT
+}
+
+function day(date: Date): string {
+  return date.toISOString().slice(0, 10)
+}
+
+function add(c: Counters, entry: Counters): void {
+  c.requests += entry.requests
+  c.done += entry.done
+  c.errors += entry.errors
+  c.tokens_in += entry.tokens_in
+  c.tokens_out += entry.tokens_out
+  c.latency_ms += entry.latency_ms
+  c.cost_usd += entry.cost_usd
+}
+
+export async function recordUsage(
+  kv: KVNamespace,
+  token: string,
+  entry: { router: string; source: UsageSource; client?: string } & Counters,
+): Promise<void> {
+  const key = `stats:${token}:${day(new Date())}`
+  const raw = await kv.get(key)
+  const bucket: StatsBucket = raw ? JSON.parse(raw) : emptyBucket()
+  add(bucket, entry)
+  add((bucket.by_router[entry.router] ??= emptyCounters()), entry)
+  add((bucket.by_source[entry.source] ??= emptyCounters()), entry)
+  const client = ((bucket.by_client ??= {})[entry.client ?? UNLABELED_CLIENT] ??= { ...emptyCounters(), by_router: {} })
+  add(client, entry)
+  add((client.by_router[entry.router] ??= emptyCounters()), entry)
+  await kv.put(key, JSON.stringify(bucket), { expirationTtl: STATS_TTL })
+}
+
+/**
+ * Record one completion against its target, pricing it from the model metadata when known.
+ * A rate-limit snapshot on the completion is saved as the router's latest, and successful
+ * calls add a latency sample for the router/model.
+ */
+export async function recordCompletion(
+  kv: KVNamespace,
+  token: string,
+  target: Target,
+  out: Pick<Completion, 'status' | 'tokens_in' | 'tokens_out' | 'latency_ms' | 'rate_limit'>,
+  source: UsageSource,
+  client?: string,
+): Promise<void> {
+  if (out.rate_limit) await recordRateLimit(kv, token, target.router.id, out.rate_limit)
+  if (out.status === 'done' && out.latency_ms > 0) {
+    await recordLatency(kv, token, `${target.router.id}/${target.model}`, out.latency_ms)
+  }
+  const info = await findModelInfo(target)
+  const cost = info?.pricing
+    ? out.tokens_in * info.pricing.prompt + out.tokens_out * info.pricing.completion
+    : 0
+  await recordUsage(kv, token, {
+    router: target.router.id,
+    source,
+    client,
+    requests: 1,
+    done: out.status === 'done' ? 1 : 0,
+    errors: out.status === 'done' ? 0 : 1,
+    tokens_in: out.tokens_in,
+    tokens_out: out.tokens_out,
+    latency_ms: out.latency_ms,
+    cost_usd: cost,
+  })
+}
+
+/** One day's bucket (empty if nothing was recorded). */
+export async function getStatsBucket(kv: KVNamespace, token: string, date: string): Promise<{ date: string } & StatsBucket> {
+  const raw = await kv.get(`stats:${token}:${date}`)
+  return { date, ...(raw ? (JSON.parse(raw) as StatsBucket) : emptyBucket()) }
+}
+
+/** Daily buckets for the last `days` days, oldest first (empty days included). */
+export async function getStats(kv: KVNamespace, token: string, days: number): Promise<Array<{ date: string } & StatsBucket>> {
+  const dates = Array.from({ length: days }, (_, i) => day(new Date(Date.now() - (days - 1 - i) * 86400_000)))
+  return Promise.all(dates.map(date => getStatsBucket(kv, token, date)))
+}
+
+/** Collapse buckets into one set of counters plus derived rates. */
+export function summarize(buckets: Counters[]) {
+  const total = emptyCounters()
+  for (const b of buckets) add(total, b)
+  return {
+    ...total,
+    failure_rate: total.requests ? total.errors / total.requests : 0,
+    avg_latency_ms: total.requests ? Math.round(total.latency_ms / total.requests) : 0,
+  }
+}
@@ -0,0 +1,179 @@
+/**
+ * Structured outputs: `response_format` is forwarded upstream as-is; replies are then checked
+ * locally (JSON parse, plus a best-effort JSON Schema check for `json_schema`) and repaired
+ * with a follow-up prompt when they don't conform. Routers that reject `response_format`
+ * are retried without it, leaving enforcement entirely local.
+ */
+
+import type { OpenAIResponse } from './routers'
+
+export type ResponseFormat =
+  | { type: 'text' }
+  | { type: 'json_object' }
+  | { type: 'json_schema'; json_schema: { name?: string; schema?: Schema; strict?: boolean } }
+
+type Schema = {
+  type?: string | string[]
+  enum?: unknown[]
+  const?: unknown
+  properties?: Record<string, Schema>
+  required?: string[]
+  additionalProperties?: boolean | Schema
+  items?: Schema
+  minItems?: number
+  maxItems?: number
+  minLength?: number
+  maxLength?: number
+  minimum?: number
+  maximum?: number
+}
+
+export const MAX_REPAIRS = 2
+
+function typeOf(value: unknown): string {
+  if (value === null) return 'null'
+  if (Array.isArray(value)) return 'array'
+  if (typeof value === 'number') return Number.isInteger(value) ? 'integer' : 'number'
+  return typeof value
+}
+
+/** First schema violation as "path: problem", or null. Covers the common keywords only. */
+export function validateSchema(value: unknown, schema: Schema, path = '$'): string | null {
+  const actual = typeOf(value)
+  if (schema.type) {
+    const allowed = Array.isArray(schema.type) ? schema.type : [schema.type]
+    const ok = allowed.some(t => t === actual || (t === 'number' && actual === 'integer'))
+    if (!ok) return `${path}: expected ${allowed.join(' | ')}, got ${actual}`
+  }
+  if (schema.enum && !schema.enum.some(e => JSON.stringify(e) === JSON.stringify(value))) {
+    return `${path}: must be one of ${JSON.stringify(schema.enum)}`
+  }
+  if ('const' in schema && JSON.stringify(schema.const) !== JSON.stringify(value)) {
+    return `${path}: must be ${JSON.stringify(schema.const)}`
+  }
+  if (typeof value === 'string') {
+    if (schema.minLength !== undefined && value.length < schema.minLength) return `${path}: shorter than ${schema.minLength}`
+    if (schema.maxLength !== undefined && value.length > schema.maxLength) return `${path}: longer than ${schema.maxLength}`
+  }
+  if (typeof value === 'number') {
+    if (schema.minimum !== undefined && value < schema.minimum) return `${path}: below ${schema.minimum}`
+    if (schema.maximum !== undefined && value > schema.maximum) return `${path}: above ${schema.maximum}`
+  }
+  if (Array.isArray(value)) {
+    if (schema.minItems !== undefined && value.length < schema.minItems) return `${path}: fewer than ${schema.minItems} items`
+    if (schema.maxItems !== undefined && value.length > schema.maxItems) return `${path}: more than ${schema.maxItems} items`
+    if (schema.items) {
+      for (const [i, item] of value.entries()) {
+        const err = validateSchema(item, schema.items, `${path}[${i}]`)
+        if (err) return err
+      }
+    }
+  }
+  if (actual === 'object') {
+    const obj = value as Record<string, unknown>
+    for (const key of schema.required ?? []) {
+      if (!(key in obj)) return `${path}: missing required property "${key}"`
+    }
+    for (const [key, v] of Object.entries(obj)) {
+      const prop = schema.properties?.[key]
+      if (prop) {
+        const err = validateSchema(v, prop, `${path}.${key}`)
+        if (err) return err
+      } else if (schema.additionalProperties === false) {
+        return `${path}: unexpected property "${key}"`
+      } else if (typeof schema.additionalProperties === 'object') {
+        const err = validateSchema(v, schema.additionalProperties, `${path}.${key}`)
+        if (err) return err
+      }
+    }
+  }
+  return null
+}
+
+/** Drop a ```json (or any other language) fence some models wrap around their output anyway. */
+export function stripFences(text: string): string {
+  const m = text.trim().match(/^```[\w+-]*[^\S\n]*\n([\s\S]*?)\n?```$/)
+  return m ? m[1] : text.trim()
+}
+
+/** Check a reply against the requested format; returns the problem, or null when it conforms. */
+export function checkFormat(content: string, format: ResponseFormat): string | null {
+  if (format.type === 'text') return null
+  let value: unknown
+  try {
+    value = JSON.parse(stripFences(content))
+  } catch (e) {
+    return `not valid JSON (${(e as Error).message})`
+  }
+  if (format.type === 'json_object') return typeOf(value) === 'object' ? null : 'expected a JSON object'
+  const schema = format.json_schema?.schema
+  return schema ? validateSchema(value, schema) : null
+}
+
+/** Whether an upstream error means the router doesn't support `response_format`. */
+export function rejectsResponseFormat(res: OpenAIResponse): boolean {
+  return Boolean(res.error && /response_format|json_schema|json mode/i.test(res.error.message))
+}
+
+/** Without upstream support, spell the format out in a system message. */
+export function formatInstruction(format: ResponseFormat): string {
+  if (format.type === 'json_schema' && format.json_schema?.schema) {
+    return `Reply with only a JSON value matching this JSON Schema, no prose or code fences:\n${JSON.stringify(format.json_schema.schema)}`
+  }
+  return 'Reply with only a single JSON object, no prose or code fences.'
+}
+
+export function repairPrompt(problem: string): string {
+  return `Your previous reply did not match the required format: ${problem}. Reply again with only the corrected JSON.`
+}
+
+/**
+ * Run a completion with local format enforcement. `call` performs one upstream request
+ * (with or without the response_format parameter); repairs reuse the conversation.
+ */
+export async function enforceFormat(params: {
+  format: ResponseFormat
+  messages: Array<{ role: string; content: string }>
+  first: OpenAIResponse
+  call: (messages: Array<{ role: string; content: string }>, withFormat: boolean) => Promise<OpenAIResponse>
+  maxRepairs?: number
+}): Promise<{ result: OpenAIResponse; repairs: number; upstream: boolean; problem: string | null; usage: { prompt_tokens: number; completion_tokens: number } }> {
+  const { format, call, maxRepairs = MAX_REPAIRS } = params
+  let messages = params.messages
+  let result = params.first
+  let upstream = true
+  const usage = { prompt_tokens: result.usage?.prompt_tokens ?? 0, completion_tokens: result.usage?.completion_tokens ?? 0 }
+  const count = (r: OpenAIResponse) => {
+    usage.prompt_tokens += r.usage?.prompt_tokens ?? 0
+    usage.completion_tokens += r.usage?.completion_tokens ?? 0
+  }
+
+  if (rejectsResponseFormat(result)) {
+    upstream = false
+    messages = [{ role: 'system', content: formatInstruction(format) }, ...messages]
+    result = await call(messages, false)
+    count(result)
+  }
+  if (result.error || format.type === 'text') return { result, repairs: 0, upstream, problem: null, usage }
+
+  let repairs = 0
+  let problem = checkFormat(result.choices[0]?.message?.content ?? '', format)
+  while (problem && repairs < maxRepairs) {
+    repairs++
+    messages = [
+      ...messages,
+      { role: 'assistant', content: result.choices[0]?.message?.content ?? '' },
+      { role: 'user', content: repairPrompt(problem) },
+    ]
+    const next = await call(messages, upstream)
+    count(next)
+    if (next.error) break
+    result = next
+    problem = checkFormat(result.choices[0]?.message?.content ?? '', format)
+  }
+
+  // Hand back clean JSON when the model fenced it
+  const content = result.choices[0]?.message?.content
+  if (!problem && content) result.choices[0].message.content = stripFences(content)
+  return { result, repairs, upstream, problem, usage }
+}
@@ -0,0 +1,131 @@
+/**
+ * Response transforms: post-processing applied to completion text before it's returned or
+ * stored. Configured per user in KV as `transforms:{token}` as a list of rules; every rule
+ * whose `client` (X-Chomp-Client label) and `route` match applies its transforms in order.
+ *
+ * Built-ins cover the common cases (strip fences, trim, JSON, attribution, regex replace).
+ * `webhook` is the escape hatch for anything else: chomp POSTs `{ text, route, client }` and
+ * uses the `text` it gets back. There's no exec here — Workers can't spawn processes.
+ */
+
+import { z } from 'zod'
+import { stripFences } from './structured'
+
+const Regex = z.string().min(1).max(200).refine(p => {
+  try {
+    new RegExp(p)
+    return true
+  } catch {
+    return false
+  }
+}, 'invalid regular expression')
+
+const TransformSchema = z.discriminatedUnion('type', [
+  z.object({ type: z.literal('strip_fences') }).strict(),
+  z.object({ type: z.literal('trim') }).strict(),
+  z.object({ type: z.literal('json') }).strict(),
+  z.object({ type: z.literal('prepend'), text: z.string().max(2000) }).strict(),
+  z.object({ type: z.literal('append'), text: z.string().max(2000) }).strict(),
+  z.object({ type: z.literal('replace'), pattern: Regex, with: z.string().max(2000).default('') }).strict(),
+  z.object({
+    type: z.literal('webhook'),
+    url: z.string().url().refine(u => u.startsWith('https://'), 'url must be https'),
+  }).strict(),
+])
+
+export type Transform = z.infer<typeof TransformSchema>
+
+export const TRANSFORM_ROUTES = ['v1', 'dispatch'] as const
+export type TransformRoute = (typeof TRANSFORM_ROUTES)[number]
+
+const RuleSchema = z.object({
+  client: z.string().optional(), // omitted = every client
+  route: z.enum(TRANSFORM_ROUTES).optional(), // omitted = every route
+  transforms: z.array(TransformSchema).min(1).max(10),
+}).strict()
+
+export const TransformsSchema = z.object({
+  rules: z.array(RuleSchema).max(20).default([]),
+}).strict()
+
+export type Transforms = z.infer<typeof TransformsSchema>
+
+export const emptyTransforms: Transforms = { rules: [] }
+
+export async function getTransforms(kv: KVNamespace, token: string): Promise<Transforms> {
+  const raw = await kv.get(`transforms:${token}`)
+  return raw ? { ...emptyTransforms, ...JSON.parse(raw) } : emptyTransforms
+}
+
+export async function saveTransforms(kv: KVNamespace, token: string, config: Transforms): Promise<void> {
+  await kv.put(`transforms:${token}`, JSON.stringify(config))
+}
+
+export async function deleteTransforms(kv: KVNamespace, token: string): Promise<void> {
+  await kv.delete(`transforms:${token}`)
+}
+
+const WEBHOOK_TIMEOUT_MS = 10000
+
+async function apply(t: Transform, text: string, ctx: { route: TransformRoute; client: string }): Promise<string> {
+  switch (t.type) {
+    case 'strip_fences':
+      return stripFences(text)
+    case 'trim':
+      return text.trim()
+    case 'json':
+      // Canonical JSON, or an error when the text (minus fences) doesn't parse
+      return JSON.stringify(JSON.parse(stripFences(text).trim()))
+    case 'prepend':
+      return t.text + text
+    case 'append':
+      return text + t.text
+    case 'replace':
+      return text.replace(new RegExp(t.pattern, 'g'), t.with)
+    case 'webhook': {
+      const res = await fetch(t.url, {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json', 'User-Agent': 'chomp-transform' },
+        body: JSON.stringify({ text, ...ctx }),
+        signal: AbortSignal.timeout(WEBHOOK_TIMEOUT_MS),
+      })
+      if (!res.ok) throw new Error(`HTTP ${res.status}`)
+      const out = await res.json() as { text?: unknown }
+      if (typeof out.text !== 'string') throw new Error('response has no text')
+      return out.text
+    }
+  }
+}
+
+export interface TransformResult {
+  text: string
+  applied: string[]
+  error?: string
+}
+
+/**
+ * Run every matching rule's transforms over `text`. A failing transform stops the chain and
+ * leaves the text as it was before that step; the error is reported, not thrown.
+ */
+export async function applyTransforms(
+  config: Transforms,
+  text: string,
+  ctx: { route: TransformRoute; client: string },
+): Promise<TransformResult | null> {
+  const transforms = config.rules
+    .filter(r => (!r.client || r.client === ctx.client) && (!r.route || r.route === ctx.route))
+    .flatMap(r => r.transforms)
+  if (transforms.length === 0) return null
+
+  const result: TransformResult = { text, applied: [] }
+  for (const t of transforms) {
+    try {
+      result.text = await apply(t, result.text, ctx)
+      result.applied.push(t.type)
+    } catch (err) {
+      result.error = `${t.type}: ${err instanceof Error ? err.message : String(err)}`
+      break
+    }
+  }
+  return result
+}
@@ -0,0 +1,65 @@
+/**
+ * Build info and the optional update check. Version, commit and build time are injected at
+ * build time (astro.config.mjs). The update check asks GitHub for the latest release, cached
+ * for six hours; set UPDATE_CHECK="false" to turn it off.
+ */
+
+const RELEASES_URL = 'https://api.github.com/repos/acoyfellow/chomp/releases/latest'
+const UPDATE_CACHE_TTL = 6 * 3600
+
+export const build = {
+  version: __CHOMP_VERSION__,
+  commit: __CHOMP_COMMIT__,
+  built: __CHOMP_BUILT__,
+}
+
+export interface UpdateInfo {
+  latest: string
+  url: string
+  update_available: boolean
+}
+
+/** Compare dotted versions ("v1.2.10" > "1.2.9"); pre-release suffixes are ignored. */
+export function isNewer(latest: string, current: string): boolean {
+  const parts = (v: string) => v.replace(/^v/, '').split('-')[0].split('.').map(n => Number(n) || 0)
+  const a = parts(latest)
+  const b = parts(current)
+  for (let i = 0; i < Math.max(a.length, b.length); i++) {
+    if ((a[i] ?? 0) !== (b[i] ?? 0)) return (a[i] ?? 0) > (b[i] ?? 0)
+  }
+  return false
+}
+
+/** Latest GitHub release vs this build, or null when disabled or GitHub can't be reached. */
+export async function checkForUpdate(env: Env): Promise<UpdateInfo | null> {
+  if (env.UPDATE_CHECK === 'false') return null
+  const cache = (caches as unknown as { default: Cache }).default
+  const cacheKey = new Request('https://chomp-cache/version/latest-release')
+
+  let release: { tag_name?: string; html_url?: string } | null = null
+  const cached = await cache.match(cacheKey)
+  if (cached) {
+    release = await cached.json()
+  } else {
+    try {
+      const res = await fetch(RELEASES_URL, {
+        headers: { 'User-Agent': 'chomp', 'Accept': 'application/vnd.github+json' },
+        signal: AbortSignal.timeout(5000),
+      })
+      if (!res.ok) return null
+      release = await res.json()
+      await cache
+        .put(cacheKey, new Response(JSON.stringify(release), { headers: { 'Cache-Control': `max-age=${UPDATE_CACHE_TTL}` } }))
+        .catch((err: unknown) => console.warn('[version] cache put failed:', err))
+    } catch {
+      return null
+    }
+  }
+
+  if (!release?.tag_name) return null
+  return {
+    latest: release.tag_name,
+    url: release.html_url ?? '',
+    update_available: isNewer(release.tag_name, build.version),
+  }
+}
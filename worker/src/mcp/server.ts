@@ -13,6 +13,7 @@ export function createMcpServer(deps: {
   token: string
   kv: KVNamespace
   ctx: ExecutionContext
+  maxInflight: number
 }) {
   const server = new McpServer({ name: "chomp", version: "1.0.0" })
 
@@ -35,7 +36,7 @@ export function createMcpServer(deps: {
         system: AskParamsZod.shape.system.describe("System prompt"),
       },
     },
-    async (args) => runTool(tools.ask(args, deps.token, deps.kv, deps.ctx)),
+    async (args) => runTool(tools.ask(args, deps.token, deps.kv, deps.ctx, deps.maxInflight)),
   )
 
   // -------------------------------------------------------------------------
@@ -53,7 +54,7 @@ export function createMcpServer(deps: {
         system: DispatchParamsZod.shape.system.describe("System prompt"),
       },
     },
-    async (args) => runTool(tools.dispatch(args, deps.token, deps.kv, deps.ctx)),
+    async (args) => runTool(tools.dispatch(args, deps.token, deps.kv, deps.ctx, deps.maxInflight)),
   )
 
   // -------------------------------------------------------------------------
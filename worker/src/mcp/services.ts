@@ -9,21 +9,19 @@ import {
   ModelError,
 } from "./errors.js"
 import type { Job } from "./schemas.js"
-import { resolveUser as resolveUserFromKV, getUserKey, getFirstAvailableRouter } from "../lib/auth.js"
-import type { UserRecord } from "../lib/auth.js"
-import { routers, getRouter, resolveRouterAndModel, callRouter } from "../lib/routers.js"
-import type { OpenAIResponse } from "../lib/routers.js"
-
-// ---------------------------------------------------------------------------
-// OpenRouter types (for free model listing)
-// ---------------------------------------------------------------------------
-
-interface OpenRouterModel {
-  id: string
-  name: string
-  context_length: number
-  top_provider?: { max_completion_tokens?: number }
-}
+import { resolveUser as resolveUserFromKV } from "../lib/auth.js"
+import { getRouter } from "../lib/routers.js"
+import { resolveTarget, isTargetError, complete } from "../lib/dispatch.js"
+import { getModelPolicy, emptyPolicy } from "../lib/policy.js"
+import type { ModelPolicy } from "../lib/policy.js"
+import { putJob, newJobId, indexJob } from "../lib/jobs.js"
+import { getRedaction } from "../lib/redact.js"
+import { acquireSlots, releaseSlots } from "../lib/inflight.js"
+import { routerPreference, markRouterUsed } from "../lib/balance.js"
+import { recordCompletion } from "../lib/stats.js"
+import { estimateTokens } from "../lib/context.js"
+import { getGuardrails, checkGuardrails, guardrailError } from "../lib/guardrails.js"
+import { scanFreeModels } from "../lib/free.js"
 
 // ---------------------------------------------------------------------------
 // Helpers
@@ -41,44 +39,12 @@ const resolveUser = (token: string, kv: KVNamespace) =>
     )
   )
 
-const fetchFreeModels = () =>
+/** OpenRouter's free models via the shared scanner (its catalog needs no key). */
+const fetchFreeModels = (policy: ModelPolicy = emptyPolicy) =>
   Effect.tryPromise({
-    try: async () => {
-      const resp = await fetch("https://openrouter.ai/api/v1/models")
-      if (!resp.ok) throw new Error(`OpenRouter returned ${resp.status}`)
-      const { data } = (await resp.json()) as { data: OpenRouterModel[] }
-      return data
-    },
+    try: () => scanFreeModels({ router: getRouter("openrouter")!, policy }),
     catch: (e) => new ModelError({ message: String(e) }),
-  }).pipe(
-    Effect.map((data) =>
-      data
-        .filter((m) => m.id.endsWith(":free"))
-        .filter((m) => {
-          const name = m.name.toLowerCase()
-          const tiny = ["1b", "3b", "7b", "8b"].some((s) => name.includes(s))
-          const big = ["70b", "80b", "180b"].some((s) => name.includes(s))
-          return !tiny || big
-        })
-        .sort((a, b) => b.context_length - a.context_length)
-    )
-  )
-
-const pickBestFreeModel = () =>
-  fetchFreeModels().pipe(
-    Effect.flatMap((models) =>
-      models.length > 0
-        ? Effect.succeed(models[0].id)
-        : Effect.fail(new ModelError({ message: "No free models available" }))
-    ),
-    Effect.mapError(
-      (e) =>
-        new DispatchError({
-          message: e instanceof ModelError ? e.message : String(e),
-          statusCode: 502,
-        })
-    )
-  )
+  })
 
 // ---------------------------------------------------------------------------
 // Service definition
@@ -95,6 +61,7 @@ export class ChompService extends Context.Tag("ChompService")<
       token: string
       kv: KVNamespace
       ctx: ExecutionContext
+      maxInflight: number
     }) => Effect.Effect<
       { id: string; model: string; status: string },
       AuthError | DispatchError
@@ -125,70 +92,59 @@ export class ChompService extends Context.Tag("ChompService")<
 
 const dispatch: ChompService["Type"]["dispatch"] = (params) =>
   Effect.gen(function* () {
-    const { prompt, system, token, kv, ctx } = params
+    const { prompt, system, token, kv, ctx, maxInflight } = params
 
     // 1. Authenticate
     const user = yield* resolveUser(token, kv)
 
-    // 2. Resolve router and model
-    let routerId = params.router
-    let model = params.model || "auto"
-
-    // If model is "auto", pick best free model via OpenRouter
-    if (model === "auto") {
-      model = yield* pickBestFreeModel()
-    }
-
-    // Check if model string contains a router prefix (e.g. "groq/llama-3.3-70b")
-    if (!routerId) {
-      const resolved = resolveRouterAndModel(model)
-      if (resolved.router) {
-        routerId = resolved.router
-        model = resolved.model
-      }
-    }
-
-    // If still no router, pick the first one the user has a key for
-    if (!routerId) {
-      const allRouterIds = routers.map((r) => r.id)
-      const found = getFirstAvailableRouter(user, allRouterIds)
-      if (!found) {
-        return yield* new DispatchError({
-          message: "No router available — user has no API keys configured",
-          statusCode: 400,
-        })
-      }
-      routerId = found
+    // 2. Resolve router and model the same way /api/dispatch does ("auto" included)
+    const { target, policy } = yield* Effect.tryPromise({
+      try: async () => {
+        const policy = await getModelPolicy(kv, token)
+        const prefer = params.router ? undefined : await routerPreference(kv, token, user, "background")
+        const promptTokens = estimateTokens(prompt) + estimateTokens(system ?? "")
+        const target = await resolveTarget(user, { router: params.router, model: params.model, promptTokens, prefer }, policy)
+        return { target, policy }
+      },
+      catch: (e) =>
+        new DispatchError({ message: `Model resolution failed: ${e}`, statusCode: 500 }),
+    })
+    if (isTargetError(target)) {
+      return yield* new DispatchError({ message: target.error, statusCode: target.status })
     }
 
-    // Resolve the router definition
-    const routerDef = getRouter(routerId)
-    if (!routerDef) {
-      return yield* new DispatchError({
-        message: `Unknown router: ${routerId}`,
-        statusCode: 400,
-      })
+    // Guardrails, as on /api/dispatch: rejected prompts never become jobs, flags go on the job
+    const { guardrails, promptCheck } = yield* Effect.tryPromise({
+      try: async () => {
+        const guardrails = await getGuardrails(kv, token)
+        const promptCheck = await checkGuardrails(guardrails, "prompt", [system, prompt].filter(Boolean).join("\n\n"), { user, policy })
+        return { guardrails, promptCheck }
+      },
+      catch: (e) =>
+        new DispatchError({ message: `Guardrail check failed: ${e}`, statusCode: 500 }),
+    })
+    if (promptCheck?.action === "reject") {
+      return yield* new DispatchError({ message: guardrailError(promptCheck).error, statusCode: 400 })
     }
 
-    // Get the user's API key for this router
-    const apiKey = getUserKey(user, routerId)
-    if (!apiKey) {
-      return yield* new DispatchError({
-        message: `No API key configured for router: ${routerDef.name}`,
-        statusCode: 400,
-      })
+    // 3. Generate job ID and take a slot (background jobs are capped per user, as in /api/dispatch)
+    const id = newJobId()
+    const busy = yield* Effect.tryPromise({
+      try: () => acquireSlots(kv, token, id, 1, maxInflight),
+      catch: (e) =>
+        new DispatchError({ message: `KV read failed: ${e}`, statusCode: 500 }),
+    })
+    if (busy) {
+      const { error } = (yield* Effect.promise(() => busy.json())) as { error: string }
+      return yield* new DispatchError({ message: error, statusCode: 429 })
     }
 
-    // 3. Generate job ID
-    const id =
-      Date.now().toString(36) + Math.random().toString(36).slice(2, 6)
-
     // 4. Build job record
     const job = {
       id,
       prompt,
       system: system || "",
-      model: `${routerId}/${model}`,
+      model: `${target.router.id}/${target.model}`,
       status: "running" as string,
       result: "",
       error: "",
@@ -197,76 +153,43 @@ const dispatch: ChompService["Type"]["dispatch"] = (params) =>
       created: new Date().toISOString(),
       finished: "",
       latency_ms: 0,
+      ...(promptCheck ? { guardrails: [promptCheck] } : {}),
     }
 
-    // 5. Persist job to KV
-    yield* Effect.tryPromise({
-      try: () =>
-        kv.put(`job:${token}:${id}`, JSON.stringify(job), {
-          expirationTtl: 86400,
-        }),
-      catch: (e) =>
-        new DispatchError({ message: `KV put failed: ${e}`, statusCode: 500 }),
-    })
-
-    // 6. Update job index
-    yield* Effect.tryPromise({
+    // 5. Persist job to KV (redacted per the user's settings) and index it; the slot is
+    // given back if that fails, since no work will run to release it
+    const redaction = yield* Effect.tryPromise({
       try: async () => {
-        const indexKey = `jobindex:${token}`
-        const raw = await kv.get(indexKey)
-        const index: string[] = raw ? JSON.parse(raw) : []
-        index.unshift(id)
-        if (index.length > 100) index.length = 100
-        await kv.put(indexKey, JSON.stringify(index))
+        try {
+          const redaction = await getRedaction(kv, token)
+          await putJob(kv, token, job, redaction)
+          await indexJob(kv, token, id)
+          return redaction
+        } catch (e) {
+          await releaseSlots(kv, token, id)
+          throw e
+        }
       },
       catch: (e) =>
-        new DispatchError({
-          message: `Job index update failed: ${e}`,
-          statusCode: 500,
-        }),
+        new DispatchError({ message: `Job store failed: ${e}`, statusCode: 500 }),
     })
 
-    // 7. Fire LLM call via waitUntil (non-blocking for dispatch)
-    const finalModel = model
-    const finalRouterDef = routerDef
-    const finalApiKey = apiKey
+    // 6. Run the completion via waitUntil (non-blocking for dispatch); retries and usage
+    // accounting are shared with the HTTP routes
     ctx.waitUntil(
       (async () => {
-        const start = Date.now()
-        try {
-          const messages: { role: string; content: string }[] = []
-          if (system) messages.push({ role: "system", content: system })
-          messages.push({ role: "user", content: prompt })
-
-          const data = await callRouter({
-            router: finalRouterDef,
-            apiKey: finalApiKey,
-            model: finalModel,
-            messages,
-          })
-
-          job.latency_ms = Date.now() - start
-          job.finished = new Date().toISOString()
-
-          if (data.error) {
-            job.status = "error"
-            job.error = data.error.message
-          } else {
-            job.status = "done"
-            job.result = data.choices?.[0]?.message?.content || ""
-            job.tokens_in = data.usage?.prompt_tokens || 0
-            job.tokens_out = data.usage?.completion_tokens || 0
-          }
-        } catch (e) {
-          job.latency_ms = Date.now() - start
-          job.finished = new Date().toISOString()
-          job.status = "error"
-          job.error = (e as Error).message
+        const out = await complete(target, prompt, system || undefined)
+        const { rate_limit: _rl, ...fields } = out
+        Object.assign(job, fields, { finished: new Date().toISOString() })
+        const responseCheck = out.status === "done" ? await checkGuardrails(guardrails, "response", out.result, { user, policy }) : null
+        if (responseCheck) {
+          job.guardrails = [...(job.guardrails ?? []), responseCheck]
+          if (responseCheck.action === "reject") Object.assign(job, { status: "error", result: "", error: guardrailError(responseCheck).error })
         }
-        await kv.put(`job:${token}:${id}`, JSON.stringify(job), {
-          expirationTtl: 86400,
-        })
-      })()
+        await putJob(kv, token, job, redaction)
+        await recordCompletion(kv, token, target, out, "mcp")
+        await markRouterUsed(kv, token, target.router.id)
+      })().finally(() => releaseSlots(kv, token, id))
     )
 
     return { id, model: job.model, status: "running" }
@@ -333,7 +256,7 @@ const listFreeModels: ChompService["Type"]["listFreeModels"] = () =>
         id: m.id,
         name: m.name,
         context_length: m.context_length,
-        max_output: m.top_provider?.max_completion_tokens || 0,
+        max_output: m.max_output,
       }))
     )
   )
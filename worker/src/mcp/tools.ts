@@ -44,6 +44,7 @@ export const ask = (
   token: string,
   kv: KVNamespace,
   ctx: ExecutionContext,
+  maxInflight: number,
 ) =>
   catchAll(
     Effect.gen(function* () {
@@ -56,6 +57,7 @@ export const ask = (
         token,
         kv,
         ctx,
+        maxInflight,
       })
       const job = yield* svc.pollUntilDone({
         jobId: dispatched.id,
@@ -88,6 +90,7 @@ export const dispatch = (
   token: string,
   kv: KVNamespace,
   ctx: ExecutionContext,
+  maxInflight: number,
 ) =>
   catchAll(
     Effect.gen(function* () {
@@ -100,6 +103,7 @@ export const dispatch = (
         token,
         kv,
         ctx,
+        maxInflight,
       })
       return {
         content: [{ type: "text" as const, text: JSON.stringify(result) }],
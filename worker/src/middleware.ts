@@ -0,0 +1,41 @@
+import { defineMiddleware } from 'astro:middleware'
+import { corsHeaders, isCorsPath } from './lib/cors'
+import { getMode, isReadOnlyMethod, isReadOnlyMcpRequest } from './lib/admin'
+import { trackRequest } from './lib/runtime'
+
+export const onRequest = defineMiddleware(async (context, next) => {
+  const { pathname } = context.url
+  const env = context.locals.runtime.env as Env
+  trackRequest()
+
+  // Read-only mode: state-changing requests get 503 (admin routes stay open to switch back,
+  // MCP requests that only read go through)
+  if (!isReadOnlyMethod(context.request.method) && !pathname.startsWith('/api/admin/')) {
+    const mode = await getMode(env)
+    if (mode.mode === 'read-only' && !(pathname === '/mcp' && await isReadOnlyMcpRequest(context.request))) {
+      const res = new Response(JSON.stringify({ error: 'maintenance', message: mode.message }), {
+        status: 503,
+        headers: { 'Content-Type': 'application/json', 'Retry-After': '300' },
+      })
+      if (isCorsPath(pathname)) {
+        for (const [k, v] of Object.entries(corsHeaders(context.request, env.CORS_ORIGINS))) res.headers.set(k, v)
+      }
+      return res
+    }
+  }
+
+  if (!isCorsPath(pathname)) return next()
+
+  const headers = corsHeaders(context.request, env.CORS_ORIGINS)
+
+  // Preflight never reaches the route
+  if (context.request.method === 'OPTIONS') {
+    return new Response(null, { status: 204, headers })
+  }
+
+  const response = await next()
+  // Upstream passthrough responses can carry immutable headers — copy them before adding CORS
+  const out = new Response(response.body, response)
+  for (const [k, v] of Object.entries(headers)) out.headers.set(k, v)
+  return out
+})
@@ -0,0 +1,35 @@
+import type { APIRoute } from 'astro'
+import { jsonResponse } from '../../../lib/auth'
+import { requireAdmin, getMode, setMode } from '../../../lib/admin'
+
+export const GET: APIRoute = async ({ request, locals }) => {
+  const env = locals.runtime.env as Env
+  const denied = requireAdmin(request, env)
+  if (denied) return denied
+
+  return jsonResponse(await getMode(env))
+}
+
+/** Switch modes: `{ mode: "read-only" | "normal", message? }`. */
+export const PUT: APIRoute = async ({ request, locals }) => {
+  const env = locals.runtime.env as Env
+  const denied = requireAdmin(request, env)
+  if (denied) return denied
+
+  let body: { mode?: string; message?: string }
+  try {
+    body = await request.json()
+  } catch {
+    return jsonResponse({ error: 'invalid JSON' }, 400)
+  }
+  if (body.mode !== 'normal' && body.mode !== 'read-only') {
+    return jsonResponse({ error: 'mode must be "normal" or "read-only"' }, 400)
+  }
+
+  const current = await getMode(env)
+  if (current.source === 'env') {
+    return jsonResponse({ error: 'MAINTENANCE_MODE is set in the environment; unset it to change modes here' }, 409)
+  }
+
+  return jsonResponse(await setMode(env, body.mode, body.message || 'chomp is in maintenance mode'))
+}
@@ -0,0 +1,22 @@
+import type { APIRoute } from 'astro'
+import { jsonResponse } from '../../../lib/auth'
+import { requireAdmin, getMode } from '../../../lib/admin'
+import { readiness } from '../../../lib/health'
+import { build } from '../../../lib/version'
+import { isolateStats, instanceStats } from '../../../lib/runtime'
+
+/** GET /api/admin/runtime — build, mode, storage health, this isolate, and instance-wide job counts. */
+export const GET: APIRoute = async ({ request, locals }) => {
+  const env = locals.runtime.env as Env
+  const denied = requireAdmin(request, env)
+  if (denied) return denied
+
+  const [mode, ready, instance] = await Promise.all([getMode(env), readiness(env), instanceStats(env.JOBS)])
+  return jsonResponse({
+    build,
+    mode,
+    storage: ready.checks.kv,
+    isolate: isolateStats(),
+    instance,
+  })
+}
@@ -0,0 +1,54 @@
+import type { APIRoute } from 'astro'
+import { extractToken, resolveUser, jsonResponse, unauthorized } from '../../../lib/auth'
+import { BalanceSchema, getBalance, saveBalance, deleteBalance, defaultBalance } from '../../../lib/balance'
+import { getRouter } from '../../../lib/routers'
+import { formatIssues } from '../../../lib/settings'
+
+export const GET: APIRoute = async ({ request, locals }) => {
+  const env = locals.runtime.env as Env
+  const token = extractToken(request)
+  if (!token) return unauthorized()
+  const user = await resolveUser(token, env.JOBS)
+  if (!user) return unauthorized()
+
+  return jsonResponse(await getBalance(env.JOBS, token))
+}
+
+/** Replace the balancing config: `{ strategy: "priority"|"weighted"|"lru"|"fastest", weights: { routerId: n } }`. */
+export const PUT: APIRoute = async ({ request, locals }) => {
+  const env = locals.runtime.env as Env
+  const token = extractToken(request)
+  if (!token) return unauthorized()
+  const user = await resolveUser(token, env.JOBS)
+  if (!user) return unauthorized()
+
+  let raw: unknown
+  try {
+    raw = await request.json()
+  } catch {
+    return jsonResponse({ error: 'invalid JSON' }, 400)
+  }
+
+  const parsed = BalanceSchema.safeParse(raw)
+  if (!parsed.success) {
+    return jsonResponse({ error: formatIssues(parsed.error) }, 400)
+  }
+  const unknown = Object.keys(parsed.data.weights).filter(id => !getRouter(id))
+  if (unknown.length > 0) {
+    return jsonResponse({ error: `weights: unknown router ${unknown.join(', ')}` }, 400)
+  }
+
+  await saveBalance(env.JOBS, token, parsed.data)
+  return jsonResponse(parsed.data)
+}
+
+export const DELETE: APIRoute = async ({ request, locals }) => {
+  const env = locals.runtime.env as Env
+  const token = extractToken(request)
+  if (!token) return unauthorized()
+  const user = await resolveUser(token, env.JOBS)
+  if (!user) return unauthorized()
+
+  await deleteBalance(env.JOBS, token)
+  return jsonResponse(defaultBalance)
+}
@@ -0,0 +1,46 @@
+import type { APIRoute } from 'astro'
+import { extractToken, resolveUser, jsonResponse, unauthorized } from '../../../lib/auth'
+import { exportBundle, importBundle } from '../../../lib/bundle'
+
+/**
+ * Export every setting as one bundle. `?include_keys=1` adds router keys, encrypted when an
+ * `X-Bundle-Passphrase` header is sent.
+ */
+export const GET: APIRoute = async ({ request, locals, url }) => {
+  const env = locals.runtime.env as Env
+  const token = extractToken(request)
+  if (!token) return unauthorized()
+  const user = await resolveUser(token, env.JOBS)
+  if (!user) return unauthorized()
+
+  const includeKeys = url.searchParams.get('include_keys') === '1'
+  const passphrase = request.headers.get('X-Bundle-Passphrase') || undefined
+  const bundle = await exportBundle(env.JOBS, token, user, { includeKeys, passphrase })
+  return new Response(JSON.stringify(bundle, null, 2), {
+    headers: {
+      'Content-Type': 'application/json',
+      'Content-Disposition': 'attachment; filename="chomp-config.json"',
+      'Cache-Control': 'no-store',
+    },
+  })
+}
+
+/** Import a bundle; sections present replace the current ones. Encrypted keys need `X-Bundle-Passphrase`. */
+export const POST: APIRoute = async ({ request, locals }) => {
+  const env = locals.runtime.env as Env
+  const token = extractToken(request)
+  if (!token) return unauthorized()
+  const user = await resolveUser(token, env.JOBS)
+  if (!user) return unauthorized()
+
+  let raw: unknown
+  try {
+    raw = await request.json()
+  } catch {
+    return jsonResponse({ error: 'invalid JSON' }, 400)
+  }
+
+  const result = await importBundle(env.JOBS, token, user, raw, request.headers.get('X-Bundle-Passphrase') || undefined)
+  if ('error' in result) return jsonResponse({ error: result.error }, 400)
+  return jsonResponse({ imported: result.imported })
+}
@@ -0,0 +1,25 @@
+import type { APIRoute } from 'astro'
+import { extractToken, resolveUser, jsonResponse, unauthorized } from '../../../lib/auth'
+import { getCallbackSecret, rotateCallbackSecret } from '../../../lib/callback'
+
+/** The secret used to sign `callback_url` deliveries (created on first read). */
+export const GET: APIRoute = async ({ request, locals }) => {
+  const env = locals.runtime.env as Env
+  const token = extractToken(request)
+  if (!token) return unauthorized()
+  const user = await resolveUser(token, env.JOBS)
+  if (!user) return unauthorized()
+
+  return jsonResponse({ secret: await getCallbackSecret(env.JOBS, token) })
+}
+
+/** Rotate the signing secret. Deliveries already in flight keep the old one. */
+export const POST: APIRoute = async ({ request, locals }) => {
+  const env = locals.runtime.env as Env
+  const token = extractToken(request)
+  if (!token) return unauthorized()
+  const user = await resolveUser(token, env.JOBS)
+  if (!user) return unauthorized()
+
+  return jsonResponse({ secret: await rotateCallbackSecret(env.JOBS, token) })
+}
@@ -0,0 +1,52 @@
+import type { APIRoute } from 'astro'
+import { extractToken, resolveUser, jsonResponse, unauthorized } from '../../../lib/auth'
+import { GuardrailsSchema, getGuardrails, saveGuardrails, deleteGuardrails, defaultGuardrails } from '../../../lib/guardrails'
+import { formatIssues } from '../../../lib/settings'
+
+export const GET: APIRoute = async ({ request, locals }) => {
+  const env = locals.runtime.env as Env
+  const token = extractToken(request)
+  if (!token) return unauthorized()
+  const user = await resolveUser(token, env.JOBS)
+  if (!user) return unauthorized()
+
+  return jsonResponse(await getGuardrails(env.JOBS, token))
+}
+
+/**
+ * Replace guardrail settings: `{ enabled?, action, denylist, max_prompt_chars?, moderation?,
+ * check_responses }`. Saving turns guardrails on unless `enabled: false`; DELETE turns them off.
+ */
+export const PUT: APIRoute = async ({ request, locals }) => {
+  const env = locals.runtime.env as Env
+  const token = extractToken(request)
+  if (!token) return unauthorized()
+  const user = await resolveUser(token, env.JOBS)
+  if (!user) return unauthorized()
+
+  let raw: unknown
+  try {
+    raw = await request.json()
+  } catch {
+    return jsonResponse({ error: 'invalid JSON' }, 400)
+  }
+
+  const parsed = GuardrailsSchema.safeParse(raw)
+  if (!parsed.success) {
+    return jsonResponse({ error: formatIssues(parsed.error) }, 400)
+  }
+
+  await saveGuardrails(env.JOBS, token, parsed.data)
+  return jsonResponse(parsed.data)
+}
+
+export const DELETE: APIRoute = async ({ request, locals }) => {
+  const env = locals.runtime.env as Env
+  const token = extractToken(request)
+  if (!token) return unauthorized()
+  const user = await resolveUser(token, env.JOBS)
+  if (!user) return unauthorized()
+
+  await deleteGuardrails(env.JOBS, token)
+  return jsonResponse(defaultGuardrails)
+}
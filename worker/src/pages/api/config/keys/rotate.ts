@@ -0,0 +1,61 @@
+import type { APIRoute } from 'astro'
+import { extractToken, resolveUser, saveUser, getUserSettings, previewKey, jsonResponse, unauthorized } from '../../../../lib/auth'
+import { getRouter, testConnection } from '../../../../lib/routers'
+import { getKeyRotations, recordKeyRotation, recordRouterTest } from '../../../../lib/settings'
+
+/** Rotation history (key previews only). */
+export const GET: APIRoute = async ({ request, locals }) => {
+  const env = locals.runtime.env as Env
+  const token = extractToken(request)
+  if (!token) return unauthorized()
+  const user = await resolveUser(token, env.JOBS)
+  if (!user) return unauthorized()
+
+  return jsonResponse(await getKeyRotations(env.JOBS, token))
+}
+
+/**
+ * Swap a configured router's key: `{ router, key }`. The new key is tested against the
+ * provider with the router's existing settings first; the old key stays in place if it fails.
+ * Calls resolve the key per request, so jobs already running keep the old key and the
+ * next request uses the new one. Model listings are cached per key, so they refresh too.
+ */
+export const PUT: APIRoute = async ({ request, locals }) => {
+  const env = locals.runtime.env as Env
+  const token = extractToken(request)
+  if (!token) return unauthorized()
+  const user = await resolveUser(token, env.JOBS)
+  if (!user) return unauthorized()
+
+  let body: { router?: string; key?: string }
+  try {
+    body = await request.json()
+  } catch {
+    return jsonResponse({ error: 'invalid JSON' }, 400)
+  }
+
+  const router = getRouter(body.router ?? '')
+  if (!router) return jsonResponse({ error: `Unknown router: ${body.router}` }, 400)
+  const key = body.key?.trim()
+  if (!key) return jsonResponse({ error: 'key required' }, 400)
+
+  const oldKey = user.keys[router.id]
+  if (!oldKey) {
+    return jsonResponse({ error: `No ${router.name} key configured; use PUT /api/config/routers/${router.id}` }, 404)
+  }
+  if (oldKey === key) return jsonResponse({ error: 'new key is the same as the current key' }, 400)
+
+  const check = await testConnection({ router, apiKey: key, settings: getUserSettings(user, router.id) })
+  if (!check.ok) {
+    return jsonResponse({ error: `${router.name} rejected the new key; nothing changed`, test: check }, 400)
+  }
+
+  user.keys = { ...user.keys, [router.id]: key }
+  await saveUser(token, user, env.JOBS)
+
+  const rotation = { router: router.id, from: previewKey(oldKey), to: previewKey(key), rotated: new Date().toISOString() }
+  await recordRouterTest(env.JOBS, token, router.id, check)
+  await recordKeyRotation(env.JOBS, token, rotation)
+
+  return jsonResponse({ ...rotation, test: check })
+}
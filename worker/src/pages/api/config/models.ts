@@ -0,0 +1,49 @@
+import type { APIRoute } from 'astro'
+import { extractToken, resolveUser, jsonResponse, unauthorized } from '../../../lib/auth'
+import { ModelPolicySchema, getModelPolicy, saveModelPolicy, deleteModelPolicy, emptyPolicy } from '../../../lib/policy'
+import { formatIssues } from '../../../lib/settings'
+
+export const GET: APIRoute = async ({ request, locals }) => {
+  const env = locals.runtime.env as Env
+  const token = extractToken(request)
+  if (!token) return unauthorized()
+  const user = await resolveUser(token, env.JOBS)
+  if (!user) return unauthorized()
+
+  return jsonResponse(await getModelPolicy(env.JOBS, token))
+}
+
+/** Replace the model policy: `{ allow, deny, min_context, notes }`. Omitted fields reset to defaults. */
+export const PUT: APIRoute = async ({ request, locals }) => {
+  const env = locals.runtime.env as Env
+  const token = extractToken(request)
+  if (!token) return unauthorized()
+  const user = await resolveUser(token, env.JOBS)
+  if (!user) return unauthorized()
+
+  let raw: unknown
+  try {
+    raw = await request.json()
+  } catch {
+    return jsonResponse({ error: 'invalid JSON' }, 400)
+  }
+
+  const parsed = ModelPolicySchema.safeParse(raw)
+  if (!parsed.success) {
+    return jsonResponse({ error: formatIssues(parsed.error) }, 400)
+  }
+
+  await saveModelPolicy(env.JOBS, token, parsed.data)
+  return jsonResponse(parsed.data)
+}
+
+export const DELETE: APIRoute = async ({ request, locals }) => {
+  const env = locals.runtime.env as Env
+  const token = extractToken(request)
+  if (!token) return unauthorized()
+  const user = await resolveUser(token, env.JOBS)
+  if (!user) return unauthorized()
+
+  await deleteModelPolicy(env.JOBS, token)
+  return jsonResponse(emptyPolicy)
+}
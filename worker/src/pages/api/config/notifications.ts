@@ -0,0 +1,52 @@
+import type { APIRoute } from 'astro'
+import { extractToken, resolveUser, jsonResponse, unauthorized } from '../../../lib/auth'
+import { NotificationsSchema, getNotifications, saveNotifications, deleteNotifications, emptyNotifications } from '../../../lib/notify'
+import { formatIssues } from '../../../lib/settings'
+
+export const GET: APIRoute = async ({ request, locals }) => {
+  const env = locals.runtime.env as Env
+  const token = extractToken(request)
+  if (!token) return unauthorized()
+  const user = await resolveUser(token, env.JOBS)
+  if (!user) return unauthorized()
+
+  return jsonResponse(await getNotifications(env.JOBS, token))
+}
+
+/**
+ * Replace notification channels:
+ * `{ channels: [{ type: "slack"|"discord"|"webhook", url, events: ["job.done","job.error","router.down","report.daily"] }] }`.
+ */
+export const PUT: APIRoute = async ({ request, locals }) => {
+  const env = locals.runtime.env as Env
+  const token = extractToken(request)
+  if (!token) return unauthorized()
+  const user = await resolveUser(token, env.JOBS)
+  if (!user) return unauthorized()
+
+  let raw: unknown
+  try {
+    raw = await request.json()
+  } catch {
+    return jsonResponse({ error: 'invalid JSON' }, 400)
+  }
+
+  const parsed = NotificationsSchema.safeParse(raw)
+  if (!parsed.success) {
+    return jsonResponse({ error: formatIssues(parsed.error) }, 400)
+  }
+
+  await saveNotifications(env.JOBS, token, parsed.data)
+  return jsonResponse(parsed.data)
+}
+
+export const DELETE: APIRoute = async ({ request, locals }) => {
+  const env = locals.runtime.env as Env
+  const token = extractToken(request)
+  if (!token) return unauthorized()
+  const user = await resolveUser(token, env.JOBS)
+  if (!user) return unauthorized()
+
+  await deleteNotifications(env.JOBS, token)
+  return jsonResponse(emptyNotifications)
+}
@@ -0,0 +1,14 @@
+import type { APIRoute } from 'astro'
+import { extractToken, resolveUser, jsonResponse, unauthorized } from '../../../lib/auth'
+import { getPresets, summarizePreset } from '../../../lib/prompts'
+
+export const GET: APIRoute = async ({ request, locals }) => {
+  const env = locals.runtime.env as Env
+  const token = extractToken(request)
+  if (!token) return unauthorized()
+  const user = await resolveUser(token, env.JOBS)
+  if (!user) return unauthorized()
+
+  const presets = await getPresets(env.JOBS, token)
+  return jsonResponse({ presets: Object.values(presets).map(summarizePreset) })
+}
@@ -0,0 +1,63 @@
+import type { APIRoute } from 'astro'
+import { extractToken, resolveUser, jsonResponse, unauthorized } from '../../../../lib/auth'
+import { PresetInputSchema, PRESET_NAME_RE, getPresets, savePreset, deletePreset } from '../../../../lib/prompts'
+import { formatIssues } from '../../../../lib/settings'
+
+/** One preset with its full version history. */
+export const GET: APIRoute = async ({ params, request, locals }) => {
+  const env = locals.runtime.env as Env
+  const token = extractToken(request)
+  if (!token) return unauthorized()
+  const user = await resolveUser(token, env.JOBS)
+  if (!user) return unauthorized()
+
+  const preset = (await getPresets(env.JOBS, token))[params.name ?? '']
+  if (!preset) return jsonResponse({ error: `unknown preset: ${params.name}` }, 404)
+  return jsonResponse(preset)
+}
+
+/**
+ * Save `{ text, description? }` as the next version of the preset, creating it on first save.
+ * Requests that name the preset without `@N` pick up the new version immediately.
+ */
+export const PUT: APIRoute = async ({ params, request, locals }) => {
+  const env = locals.runtime.env as Env
+  const token = extractToken(request)
+  if (!token) return unauthorized()
+  const user = await resolveUser(token, env.JOBS)
+  if (!user) return unauthorized()
+
+  const name = params.name ?? ''
+  if (!PRESET_NAME_RE.test(name)) {
+    return jsonResponse({ error: 'preset names are lowercase letters, digits, - and _ (max 64)' }, 400)
+  }
+
+  let raw: unknown
+  try {
+    raw = await request.json()
+  } catch {
+    return jsonResponse({ error: 'invalid JSON' }, 400)
+  }
+
+  const parsed = PresetInputSchema.safeParse(raw)
+  if (!parsed.success) {
+    return jsonResponse({ error: formatIssues(parsed.error) }, 400)
+  }
+
+  const saved = await savePreset(env.JOBS, token, name, parsed.data)
+  if ('error' in saved) return jsonResponse({ error: saved.error }, 409)
+  return jsonResponse(saved)
+}
+
+export const DELETE: APIRoute = async ({ params, request, locals }) => {
+  const env = locals.runtime.env as Env
+  const token = extractToken(request)
+  if (!token) return unauthorized()
+  const user = await resolveUser(token, env.JOBS)
+  if (!user) return unauthorized()
+
+  if (!(await deletePreset(env.JOBS, token, params.name ?? ''))) {
+    return jsonResponse({ error: `unknown preset: ${params.name}` }, 404)
+  }
+  return jsonResponse({ deleted: params.name })
+}
@@ -0,0 +1,52 @@
+import type { APIRoute } from 'astro'
+import { extractToken, resolveUser, jsonResponse, unauthorized } from '../../../lib/auth'
+import { RedactionSchema, getRedaction, saveRedaction, deleteRedaction, defaultRedaction } from '../../../lib/redact'
+import { formatIssues } from '../../../lib/settings'
+
+export const GET: APIRoute = async ({ request, locals }) => {
+  const env = locals.runtime.env as Env
+  const token = extractToken(request)
+  if (!token) return unauthorized()
+  const user = await resolveUser(token, env.JOBS)
+  if (!user) return unauthorized()
+
+  return jsonResponse(await getRedaction(env.JOBS, token))
+}
+
+/**
+ * Replace redaction settings: `{ prompts, results, truncate_chars }` where prompts/results are
+ * "none" | "truncate" | "hash" | "drop". Applies to jobs written from now on.
+ */
+export const PUT: APIRoute = async ({ request, locals }) => {
+  const env = locals.runtime.env as Env
+  const token = extractToken(request)
+  if (!token) return unauthorized()
+  const user = await resolveUser(token, env.JOBS)
+  if (!user) return unauthorized()
+
+  let raw: unknown
+  try {
+    raw = await request.json()
+  } catch {
+    return jsonResponse({ error: 'invalid JSON' }, 400)
+  }
+
+  const parsed = RedactionSchema.safeParse(raw)
+  if (!parsed.success) {
+    return jsonResponse({ error: formatIssues(parsed.error) }, 400)
+  }
+
+  await saveRedaction(env.JOBS, token, parsed.data)
+  return jsonResponse(parsed.data)
+}
+
+export const DELETE: APIRoute = async ({ request, locals }) => {
+  const env = locals.runtime.env as Env
+  const token = extractToken(request)
+  if (!token) return unauthorized()
+  const user = await resolveUser(token, env.JOBS)
+  if (!user) return unauthorized()
+
+  await deleteRedaction(env.JOBS, token)
+  return jsonResponse(defaultRedaction)
+}
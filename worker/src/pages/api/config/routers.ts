@@ -0,0 +1,16 @@
+import type { APIRoute } from 'astro'
+import { extractToken, resolveUser, jsonResponse, unauthorized } from '../../../lib/auth'
+import { routers } from '../../../lib/routers'
+import { describeRouter, getRouterTests } from '../../../lib/settings'
+import { getRateLimits } from '../../../lib/ratelimit'
+
+export const GET: APIRoute = async ({ request, locals }) => {
+  const env = locals.runtime.env as Env
+  const token = extractToken(request)
+  if (!token) return unauthorized()
+  const user = await resolveUser(token, env.JOBS)
+  if (!user) return unauthorized()
+
+  const [tests, limits] = await Promise.all([getRouterTests(env.JOBS, token), getRateLimits(env.JOBS, token)])
+  return jsonResponse({ routers: routers.map(r => describeRouter(r, user, tests[r.id], limits[r.id])) })
+}
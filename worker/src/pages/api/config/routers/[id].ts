@@ -0,0 +1,90 @@
+import type { APIRoute } from 'astro'
+import { extractToken, resolveUser, saveUser, jsonResponse, unauthorized } from '../../../../lib/auth'
+import { getRouter, testConnection } from '../../../../lib/routers'
+import {
+  routerSettingsSchema,
+  formatIssues,
+  describeRouter,
+  getRouterTests,
+  recordRouterTest,
+  applyRouterSettings,
+  clearRouterSettings,
+} from '../../../../lib/settings'
+import { getRateLimits } from '../../../../lib/ratelimit'
+
+export const GET: APIRoute = async ({ params, request, locals }) => {
+  const env = locals.runtime.env as Env
+  const token = extractToken(request)
+  if (!token) return unauthorized()
+  const user = await resolveUser(token, env.JOBS)
+  if (!user) return unauthorized()
+
+  const router = getRouter(params.id ?? '')
+  if (!router) return jsonResponse({ error: `Unknown router: ${params.id}` }, 404)
+
+  const [tests, limits] = await Promise.all([getRouterTests(env.JOBS, token), getRateLimits(env.JOBS, token)])
+  return jsonResponse(describeRouter(router, user, tests[router.id], limits[router.id]))
+}
+
+/**
+ * Replace the whole settings document for one router: `{ key, settings, test? }`.
+ * Every required field must be present, so a router is either fully configured or untouched.
+ * With `test: true` the values are checked against the provider before anything is saved.
+ */
+export const PUT: APIRoute = async ({ params, request, locals }) => {
+  const env = locals.runtime.env as Env
+  const token = extractToken(request)
+  if (!token) return unauthorized()
+  const user = await resolveUser(token, env.JOBS)
+  if (!user) return unauthorized()
+
+  const router = getRouter(params.id ?? '')
+  if (!router) return jsonResponse({ error: `Unknown router: ${params.id}` }, 404)
+
+  let raw: unknown
+  try {
+    raw = await request.json()
+  } catch {
+    return jsonResponse({ error: 'invalid JSON' }, 400)
+  }
+
+  const parsed = routerSettingsSchema(router).safeParse(raw)
+  if (!parsed.success) {
+    return jsonResponse({ error: formatIssues(parsed.error) }, 400)
+  }
+  const input = parsed.data
+
+  if (input.test) {
+    const check = await testConnection({
+      router,
+      apiKey: input.key,
+      settings: input.settings as Record<string, string>,
+    })
+    if (!check.ok) {
+      return jsonResponse({ error: `${router.name} connection failed`, test: check }, 400)
+    }
+    await recordRouterTest(env.JOBS, token, router.id, check)
+  }
+
+  applyRouterSettings(user, router.id, input)
+  await saveUser(token, user, env.JOBS)
+
+  const [tests, limits] = await Promise.all([getRouterTests(env.JOBS, token), getRateLimits(env.JOBS, token)])
+  return jsonResponse(describeRouter(router, user, tests[router.id], limits[router.id]))
+}
+
+export const DELETE: APIRoute = async ({ params, request, locals }) => {
+  const env = locals.runtime.env as Env
+  const token = extractToken(request)
+  if (!token) return unauthorized()
+  const user = await resolveUser(token, env.JOBS)
+  if (!user) return unauthorized()
+
+  const router = getRouter(params.id ?? '')
+  if (!router) return jsonResponse({ error: `Unknown router: ${params.id}` }, 404)
+
+  clearRouterSettings(user, router.id)
+  await saveUser(token, user, env.JOBS)
+
+  return jsonResponse(describeRouter(router, user))
+}
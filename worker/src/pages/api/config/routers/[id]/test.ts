@@ -0,0 +1,47 @@
+import type { APIRoute } from 'astro'
+import { extractToken, resolveUser, getUserKey, getUserSettings, jsonResponse, unauthorized } from '../../../../../lib/auth'
+import { getRouter, missingSettings, testConnection } from '../../../../../lib/routers'
+import { recordRouterTest } from '../../../../../lib/settings'
+import { notify } from '../../../../../lib/notify'
+
+/**
+ * Test the stored key for one router with real calls (model list + 1-token completion).
+ * Optional body `{ model }` picks the completion model. The result is recorded as the
+ * router's `last_test`, pass or fail.
+ */
+export const POST: APIRoute = async ({ params, request, locals }) => {
+  const env = locals.runtime.env as Env
+  const token = extractToken(request)
+  if (!token) return unauthorized()
+  const user = await resolveUser(token, env.JOBS)
+  if (!user) return unauthorized()
+
+  const router = getRouter(params.id ?? '')
+  if (!router) return jsonResponse({ error: `Unknown router: ${params.id}` }, 404)
+
+  const apiKey = getUserKey(user, router.id)
+  if (!apiKey) return jsonResponse({ error: `No ${router.name} key configured` }, 400)
+
+  const settings = getUserSettings(user, router.id)
+  const missing = missingSettings(router, settings)
+  if (missing.length > 0) {
+    return jsonResponse({ error: `${router.name} is missing settings: ${missing.join(', ')}` }, 400)
+  }
+
+  let body: { model?: string } = {}
+  try {
+    body = await request.json()
+  } catch {
+    // empty body is fine
+  }
+
+  const result = await testConnection({ router, apiKey, settings, model: body.model })
+  await recordRouterTest(env.JOBS, token, router.id, result)
+  if (!result.ok) {
+    const error = result.models.error || result.completion.error
+    locals.runtime.ctx.waitUntil(notify(env.JOBS, token, 'router.down',
+      `chomp: ${router.name} connection test failed: ${error}`, { router: router.id, test: result }))
+  }
+
+  return jsonResponse({ router: router.id, ...result })
+}
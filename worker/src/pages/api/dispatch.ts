@@ -1,22 +1,26 @@
 import type { APIRoute } from 'astro'
-import { extractToken, resolveUser, getUserKey, getFirstAvailableRouter, jsonResponse, unauthorized } from '../../lib/auth'
-import { routers, getRouter, resolveRouterAndModel, callRouter } from '../../lib/routers'
-
-async function pickBestFreeModel(): Promise<string> {
-  const resp = await fetch('https://openrouter.ai/api/v1/models')
-  const { data } = await resp.json() as { data: { id: string; context_length: number; name: string }[] }
-  const free = data
-    .filter(m => m.id.endsWith(':free'))
-    .filter(m => {
-      const name = m.name.toLowerCase()
-      const tiny = ['1b', '3b', '7b', '8b'].some(s => name.includes(s))
-      const big = ['70b', '80b', '180b'].some(s => name.includes(s))
-      return !tiny || big
-    })
-    .sort((a, b) => b.context_length - a.context_length)
-  if (!free.length) throw new Error('No free models available')
-  return free[0].id
-}
+import { extractToken, resolveUser, jsonResponse, unauthorized } from '../../lib/auth'
+import { resolveTarget, isTargetError, complete } from '../../lib/dispatch'
+import { newJobId, putJob, indexJob, parseJobLabels } from '../../lib/jobs'
+import { getModelPolicy } from '../../lib/policy'
+import type { Job } from '../../lib/jobs'
+import { lookupContextWindow, estimateTokens } from '../../lib/context'
+import { needsCompression, compressPrompt } from '../../lib/compress'
+import { recordCompletion, clientLabel } from '../../lib/stats'
+import { notifyJob } from '../../lib/notify'
+import { getRedaction } from '../../lib/redact'
+import { acquireSlots, releaseSlots, inflightLimit } from '../../lib/inflight'
+import { routerPreference, markRouterUsed } from '../../lib/balance'
+import { parseCallbackUrl, deliverCallback } from '../../lib/callback'
+import { sleep } from '../../lib/ratelimit'
+import { resolvePreset, recordRecentPrompt } from '../../lib/prompts'
+import { getGuardrails, checkGuardrails, guardrailError } from '../../lib/guardrails'
+import type { GuardrailResult } from '../../lib/guardrails'
+import { getTransforms, applyTransforms } from '../../lib/transforms'
+
+/** `wait: true` holds the request this long by default; a number of seconds overrides, up to the max. */
+const DEFAULT_WAIT_S = 30
+const MAX_WAIT_S = 90
 
 export const POST: APIRoute = async ({ request, locals }) => {
   const env = locals.runtime.env as Env
@@ -26,7 +30,19 @@ export const POST: APIRoute = async ({ request, locals }) => {
   const user = await resolveUser(token, env.JOBS)
   if (!user) return unauthorized()
 
-  let body: { prompt?: string; model?: string; system?: string; router?: string }
+  let body: {
+    prompt?: string
+    model?: string
+    system?: string
+    preset?: string
+    router?: string
+    compress?: boolean
+    summarizer?: string
+    tags?: unknown
+    metadata?: unknown
+    callback_url?: unknown
+    wait?: boolean | number
+  }
   try {
     body = await request.json()
   } catch {
@@ -35,53 +51,53 @@ export const POST: APIRoute = async ({ request, locals }) => {
   if (!body.prompt) {
     return jsonResponse({ error: 'prompt required' }, 400)
   }
-
-  // --- Router resolution chain ---
-  let routerId: string | undefined = body.router
-  let model = body.model || 'auto'
-
-  // If no explicit router, try extracting from model prefix (e.g. "groq/llama-3.3-70b")
-  if (!routerId && model !== 'auto') {
-    const resolved = resolveRouterAndModel(model)
-    if (resolved.router) {
-      routerId = resolved.router
-      model = resolved.model
-    }
+  const labels = parseJobLabels(body)
+  if ('error' in labels) return jsonResponse({ error: labels.error }, 400)
+  const callback = body.callback_url === undefined ? undefined : parseCallbackUrl(body.callback_url)
+  if (callback && 'error' in callback) return jsonResponse({ error: callback.error }, 400)
+  if (body.wait !== undefined && typeof body.wait !== 'boolean' && !(typeof body.wait === 'number' && body.wait > 0)) {
+    return jsonResponse({ error: 'wait must be true or a number of seconds' }, 400)
   }
-
-  // If still no router, pick the first one the user has a key for
-  if (!routerId) {
-    routerId = getFirstAvailableRouter(user, routers.map(r => r.id)) ?? undefined
+  // A preset's text goes first; an explicit `system` is appended to it
+  const preset = body.preset === undefined ? undefined : await resolvePreset(env.JOBS, token, body.preset)
+  if (preset && 'error' in preset) return jsonResponse({ error: preset.error }, 400)
+  const system = [preset?.text, body.system].filter(Boolean).join('\n\n')
+  const waitMs = body.wait === true ? DEFAULT_WAIT_S * 1000 : typeof body.wait === 'number' ? Math.min(body.wait, MAX_WAIT_S) * 1000 : 0
+
+  // --- Router + model resolution ---
+  const policy = await getModelPolicy(env.JOBS, token)
+  const promptTokens = estimateTokens(body.prompt) + estimateTokens(system)
+  const prefer = body.router ? undefined : await routerPreference(env.JOBS, token, user, 'background')
+  const target = await resolveTarget(user, { router: body.router, model: body.model, promptTokens, prefer }, policy)
+  if (isTargetError(target)) {
+    return jsonResponse({ error: target.error }, target.status)
   }
 
-  if (!routerId) {
-    return jsonResponse({ error: 'No router available — configure at least one API key' }, 400)
+  // Long prompts are summarized in chunks; the summarizer defaults to the target itself
+  const summarizer = body.summarizer ? await resolveTarget(user, { model: body.summarizer }, policy) : target
+  if (isTargetError(summarizer)) {
+    return jsonResponse({ error: `summarizer: ${summarizer.error}` }, summarizer.status)
   }
 
-  const routerDef = getRouter(routerId)
-  if (!routerDef) {
-    return jsonResponse({ error: `Unknown router: ${routerId}` }, 400)
-  }
+  // Guardrails see the full prompt; flagged violations are recorded on the job
+  const guardrails = await getGuardrails(env.JOBS, token)
+  const flagged: GuardrailResult[] = []
+  const promptCheck = await checkGuardrails(guardrails, 'prompt', [system, body.prompt].filter(Boolean).join('\n\n'), { user, policy })
+  if (promptCheck?.action === 'reject') return jsonResponse(guardrailError(promptCheck), 400)
+  if (promptCheck) flagged.push(promptCheck)
 
-  // --- Model resolution ---
-  if (model === 'auto') {
-    if (routerId === 'openrouter') {
-      try { model = await pickBestFreeModel() }
-      catch (e) {
-        return jsonResponse({ error: (e as Error).message }, 502)
-      }
-    } else {
-      model = routerDef.defaultModel
-    }
-  }
+  // Background jobs are capped per user; /v1 isn't, so interactive calls always go first
+  const id = newJobId()
+  const busy = await acquireSlots(env.JOBS, token, id, 1, inflightLimit(env))
+  if (busy) return busy
 
-  const id = Date.now().toString(36) + Math.random().toString(36).slice(2, 6)
-  const job = {
+  const job: Job = {
     id,
     prompt: body.prompt,
-    system: body.system || '',
-    model,
-    router: routerId,
+    system,
+    ...(preset ? { preset: preset.ref } : {}),
+    model: target.model,
+    router: target.router.id,
     status: 'running',
     result: '',
     error: '',
@@ -90,65 +106,91 @@ export const POST: APIRoute = async ({ request, locals }) => {
     created: new Date().toISOString(),
     finished: '',
     latency_ms: 0,
+    compression: null,
+    tags: labels.tags,
+    metadata: labels.metadata,
+    ...(callback ? { callback_url: callback.url } : {}),
+    ...(flagged.length ? { guardrails: flagged } : {}),
   }
 
   // Scope jobs to user token
-  await env.JOBS.put(`job:${token}:${id}`, JSON.stringify(job), { expirationTtl: 86400 })
+  const redaction = await getRedaction(env.JOBS, token)
+  await putJob(env.JOBS, token, job, redaction)
+  await indexJob(env.JOBS, token, id)
+  // Recent prompts feed autocomplete; redacted prompts stay out of it
+  if (redaction.prompts === 'none') locals.runtime.ctx.waitUntil(recordRecentPrompt(env.JOBS, token, body.prompt!))
 
-  // User-scoped job index
-  const indexKey = `jobindex:${token}`
-  const indexRaw = await env.JOBS.get(indexKey)
-  const index: string[] = indexRaw ? JSON.parse(indexRaw) : []
-  index.unshift(id)
-  if (index.length > 100) index.length = 100
-  await env.JOBS.put(indexKey, JSON.stringify(index))
+  const client = clientLabel(request)
+  const ctx = locals.runtime.ctx
+
+  // Store the finished job, then tell the caller: notification channels and callback_url.
+  // The callback gets the unredacted job (it's the caller's own endpoint); the stored copy
+  // records the delivery outcome.
+  let stored: () => void = () => {}
+  const finished = new Promise<void>(resolve => { stored = resolve })
+  const finish = async () => {
+    await putJob(env.JOBS, token, job, redaction)
+    stored()
+    await notifyJob(env.JOBS, token, job)
+    if (job.callback_url) {
+      job.callback = await deliverCallback(env.JOBS, token, job.callback_url, job)
+      await putJob(env.JOBS, token, job, redaction)
+    }
+  }
 
   // Fire LLM call with USER's key for the resolved router
-  const ctx = locals.runtime.ctx
-  const apiKey = getUserKey(user, routerId)
-
-  ctx.waitUntil((async () => {
-    const start = Date.now()
-    try {
-      if (!apiKey) {
-        job.status = 'error'
-        job.error = `No ${routerDef.name} key configured`
-        job.finished = new Date().toISOString()
-        await env.JOBS.put(`job:${token}:${id}`, JSON.stringify(job), { expirationTtl: 86400 })
-        return
+  const work = (async () => {
+    let prompt = body.prompt!
+    if (body.compress !== false) {
+      const window = await lookupContextWindow(target)
+      if (needsCompression(prompt, job.system, window)) {
+        try {
+          const summarizerWindow = summarizer === target ? window : await lookupContextWindow(summarizer)
+          const compressed = await compressPrompt({ prompt, contextWindow: window, summarizer, summarizerWindow })
+          prompt = compressed.prompt
+          job.compression = compressed.compression
+          await recordCompletion(env.JOBS, token, summarizer, { ...compressed.compression, status: 'done', latency_ms: 0 }, 'dispatch', client)
+        } catch (e) {
+          Object.assign(job, { status: 'error', error: (e as Error).message, finished: new Date().toISOString() })
+          await finish()
+          return
+        }
       }
+    }
 
-      const messages: { role: string; content: string }[] = []
-      if (body.system) messages.push({ role: 'system', content: body.system })
-      messages.push({ role: 'user', content: body.prompt! })
-
-      const data = await callRouter({
-        router: routerDef,
-        apiKey,
-        model,
-        messages,
-      })
-
-      job.latency_ms = Date.now() - start
-      job.finished = new Date().toISOString()
-
-      if (data.error) {
-        job.status = 'error'
-        job.error = data.error.message || `${routerDef.name} error`
-      } else {
-        job.status = 'done'
-        job.result = data.choices?.[0]?.message?.content || ''
-        job.tokens_in = data.usage?.prompt_tokens || 0
-        job.tokens_out = data.usage?.completion_tokens || 0
+    const out = await complete(target, prompt, system || undefined)
+    const { rate_limit: _rl, ...fields } = out
+    Object.assign(job, fields, { finished: new Date().toISOString() })
+    const responseCheck = out.status === 'done' ? await checkGuardrails(guardrails, 'response', out.result, { user, policy }) : null
+    if (responseCheck) {
+      job.guardrails = [...(job.guardrails ?? []), responseCheck]
+      if (responseCheck.action === 'reject') Object.assign(job, { status: 'error', result: '', error: guardrailError(responseCheck).error })
+    }
+    if (job.status === 'done') {
+      const transformed = await applyTransforms(await getTransforms(env.JOBS, token), job.result, { route: 'dispatch', client })
+      if (transformed) {
+        const { text, ...report } = transformed
+        job.result = text
+        job.transforms = report
       }
-    } catch (e) {
-      job.latency_ms = Date.now() - start
-      job.finished = new Date().toISOString()
-      job.status = 'error'
-      job.error = (e as Error).message
     }
-    await env.JOBS.put(`job:${token}:${id}`, JSON.stringify(job), { expirationTtl: 86400 })
-  })())
+    await recordCompletion(env.JOBS, token, target, out, 'dispatch', client)
+    await markRouterUsed(env.JOBS, token, target.router.id)
+    await finish()
+  })().finally(() => releaseSlots(env.JOBS, token, id))
+  ctx.waitUntil(work)
+
+  // Sync mode: answer with the finished job, or 202 with the running one if it takes too long
+  if (waitMs) {
+    await Promise.race([finished, work.catch(() => {}), sleep(waitMs)])
+    return jsonResponse({ ...job, ...(target.reason ? { selection: target.reason } : {}) }, job.status === 'running' ? 202 : 200)
+  }
 
-  return jsonResponse({ id, model, router: routerId, status: 'running' })
+  return jsonResponse({
+    id,
+    model: target.model,
+    router: target.router.id,
+    status: 'running',
+    ...(target.reason ? { selection: target.reason } : {}),
+  })
 }
@@ -0,0 +1,162 @@
+import type { APIRoute } from 'astro'
+import { extractToken, resolveUser, jsonResponse, unauthorized } from '../../../lib/auth'
+import { resolveTarget, resolveTargets, isTargetError, complete } from '../../../lib/dispatch'
+import type { TargetInput } from '../../../lib/dispatch'
+import { defaultCandidates, judge, DEFAULT_CANDIDATES, MAX_CANDIDATES } from '../../../lib/judge'
+import { newJobId, putJob, indexJob, parseJobLabels } from '../../../lib/jobs'
+import { getModelPolicy } from '../../../lib/policy'
+import { recordCompletion, clientLabel } from '../../../lib/stats'
+import { notifyJob } from '../../../lib/notify'
+import { getRedaction } from '../../../lib/redact'
+import { acquireSlots, releaseSlots, inflightLimit } from '../../../lib/inflight'
+import { getGuardrails, checkGuardrails, guardrailError } from '../../../lib/guardrails'
+import { recordRecentPrompt } from '../../../lib/prompts'
+
+export const POST: APIRoute = async ({ request, locals }) => {
+  const env = locals.runtime.env as Env
+
+  const token = extractToken(request)
+  if (!token) return unauthorized()
+  const user = await resolveUser(token, env.JOBS)
+  if (!user) return unauthorized()
+
+  let body: {
+    prompt?: string
+    system?: string
+    n?: number
+    targets?: TargetInput[]
+    judge?: string
+    tags?: unknown
+    metadata?: unknown
+  }
+  try {
+    body = await request.json()
+  } catch {
+    return jsonResponse({ error: 'invalid JSON' }, 400)
+  }
+  if (!body.prompt) {
+    return jsonResponse({ error: 'prompt required' }, 400)
+  }
+  const labels = parseJobLabels(body)
+  if ('error' in labels) return jsonResponse({ error: labels.error }, 400)
+
+  const policy = await getModelPolicy(env.JOBS, token)
+  const n = Math.min(Math.max(body.n ?? DEFAULT_CANDIDATES, 2), MAX_CANDIDATES)
+  const inputs = Array.isArray(body.targets) && body.targets.length > 0
+    ? body.targets.slice(0, MAX_CANDIDATES)
+    : await defaultCandidates(user, n, policy)
+  if (inputs.length < 2) {
+    return jsonResponse({ error: 'best-of-N needs at least 2 candidates — pass targets or configure more routers' }, 400)
+  }
+
+  const candidates = await resolveTargets(user, inputs, policy)
+  if (!Array.isArray(candidates)) {
+    return jsonResponse({ error: candidates.error, target: candidates.target }, candidates.status)
+  }
+
+  // Judge defaults to the user's first router on its default model
+  const judgeTarget = await resolveTarget(user, { model: body.judge }, policy)
+  if (isTargetError(judgeTarget)) {
+    return jsonResponse({ error: `judge: ${judgeTarget.error}` }, judgeTarget.status)
+  }
+
+  const check = await checkGuardrails(await getGuardrails(env.JOBS, token), 'prompt', [body.system, body.prompt].filter(Boolean).join('\n\n'), { user, policy })
+  if (check?.action === 'reject') return jsonResponse(guardrailError(check), 400)
+
+  const id = newJobId()
+  const busy = await acquireSlots(env.JOBS, token, id, candidates.length + 1, inflightLimit(env))
+  if (busy) return busy
+
+  const job = {
+    id,
+    kind: 'best',
+    prompt: body.prompt,
+    system: body.system || '',
+    status: 'running',
+    result: '',
+    error: '',
+    winner: null as { router: string; model: string } | null,
+    rationale: '',
+    judge: { router: judgeTarget.router.id, model: judgeTarget.model },
+    candidates: candidates.map(c => ({
+      router: c.router.id,
+      model: c.model,
+      status: 'running',
+      error: '',
+      error_class: '',
+      score: 0,
+      latency_ms: 0,
+    })),
+    tokens_in: 0,
+    tokens_out: 0,
+    created: new Date().toISOString(),
+    finished: '',
+    latency_ms: 0,
+    tags: labels.tags,
+    metadata: labels.metadata,
+    ...(check ? { guardrails: [check] } : {}),
+  }
+
+  const redaction = await getRedaction(env.JOBS, token)
+  await putJob(env.JOBS, token, job, redaction)
+  await indexJob(env.JOBS, token, id)
+  // Recent prompts feed autocomplete; redacted prompts stay out of it
+  if (redaction.prompts === 'none') locals.runtime.ctx.waitUntil(recordRecentPrompt(env.JOBS, token, body.prompt!))
+
+  const client = clientLabel(request)
+  const ctx = locals.runtime.ctx
+  ctx.waitUntil((async () => {
+    const start = Date.now()
+    const outs = await Promise.all(candidates.map(c => complete(c, body.prompt!, body.system)))
+    outs.forEach((out, i) => {
+      Object.assign(job.candidates[i], { status: out.status, error: out.error, error_class: out.error_class ?? '', latency_ms: out.latency_ms })
+      job.tokens_in += out.tokens_in
+      job.tokens_out += out.tokens_out
+    })
+
+    // Only successful answers go before the judge; map verdict indices back to candidates
+    const ok = outs.map((out, i) => ({ out, i })).filter(({ out }) => out.status === 'done')
+    if (ok.length === 0) {
+      job.status = 'error'
+      job.error = 'all candidates failed'
+    } else if (ok.length === 1) {
+      job.status = 'done'
+      job.result = ok[0].out.result
+      job.winner = { router: job.candidates[ok[0].i].router, model: job.candidates[ok[0].i].model }
+      job.rationale = 'only one candidate succeeded'
+    } else {
+      const verdict = await judge(judgeTarget, body.prompt!, ok.map(({ out }) => out.result))
+      job.tokens_in += verdict.tokens_in
+      job.tokens_out += verdict.tokens_out
+      await recordCompletion(env.JOBS, token, judgeTarget, {
+        status: verdict.verdict ? 'done' : 'error',
+        tokens_in: verdict.tokens_in,
+        tokens_out: verdict.tokens_out,
+        latency_ms: 0,
+      }, 'best', client)
+
+      const pick = verdict.verdict ? ok[verdict.verdict.winner] : ok[0]
+      verdict.verdict?.scores.forEach((score, j) => { job.candidates[ok[j].i].score = score })
+      job.status = 'done'
+      job.result = pick.out.result
+      job.winner = { router: job.candidates[pick.i].router, model: job.candidates[pick.i].model }
+      job.rationale = verdict.verdict
+        ? verdict.verdict.rationale
+        : `judge failed (${verdict.error}) — fell back to the first successful candidate`
+    }
+
+    job.latency_ms = Date.now() - start
+    job.finished = new Date().toISOString()
+    await putJob(env.JOBS, token, job, redaction)
+    for (const [i, out] of outs.entries()) await recordCompletion(env.JOBS, token, candidates[i], out, 'best', client)
+    await notifyJob(env.JOBS, token, { ...job, ...job.winner })
+  })().finally(() => releaseSlots(env.JOBS, token, id)))
+
+  return jsonResponse({
+    id,
+    kind: 'best',
+    candidates: job.candidates.map(c => ({ router: c.router, model: c.model })),
+    judge: job.judge,
+    status: 'running',
+  })
+}
@@ -0,0 +1,120 @@
+import type { APIRoute } from 'astro'
+import { extractToken, resolveUser, jsonResponse, unauthorized } from '../../../lib/auth'
+import { resolveTargets, complete } from '../../../lib/dispatch'
+import type { TargetInput, Completion } from '../../../lib/dispatch'
+import { newJobId, putJob, indexJob, parseJobLabels } from '../../../lib/jobs'
+import { getModelPolicy } from '../../../lib/policy'
+import { recordCompletion, clientLabel } from '../../../lib/stats'
+import { notifyJob } from '../../../lib/notify'
+import { getRedaction } from '../../../lib/redact'
+import { acquireSlots, releaseSlots, inflightLimit } from '../../../lib/inflight'
+import { getGuardrails, checkGuardrails, guardrailError } from '../../../lib/guardrails'
+import { recordRecentPrompt } from '../../../lib/prompts'
+
+const MAX_TARGETS = 8
+
+interface FanoutResult extends Omit<Completion, 'status'> {
+  router: string
+  model: string
+  status: string
+}
+
+export const POST: APIRoute = async ({ request, locals }) => {
+  const env = locals.runtime.env as Env
+
+  const token = extractToken(request)
+  if (!token) return unauthorized()
+  const user = await resolveUser(token, env.JOBS)
+  if (!user) return unauthorized()
+
+  let body: { prompt?: string; system?: string; targets?: TargetInput[]; tags?: unknown; metadata?: unknown }
+  try {
+    body = await request.json()
+  } catch {
+    return jsonResponse({ error: 'invalid JSON' }, 400)
+  }
+  if (!body.prompt) {
+    return jsonResponse({ error: 'prompt required' }, 400)
+  }
+  const labels = parseJobLabels(body)
+  if ('error' in labels) return jsonResponse({ error: labels.error }, 400)
+  if (!Array.isArray(body.targets) || body.targets.length === 0) {
+    return jsonResponse({ error: 'targets required (e.g. ["groq/llama-3.3-70b-versatile", "openrouter/auto"])' }, 400)
+  }
+  if (body.targets.length > MAX_TARGETS) {
+    return jsonResponse({ error: `at most ${MAX_TARGETS} targets` }, 400)
+  }
+
+  // Resolve every target up front so a typo fails the whole request, not one slot
+  const policy = await getModelPolicy(env.JOBS, token)
+  const targets = await resolveTargets(user, body.targets, policy)
+  if (!Array.isArray(targets)) {
+    return jsonResponse({ error: targets.error, target: targets.target }, targets.status)
+  }
+
+  const check = await checkGuardrails(await getGuardrails(env.JOBS, token), 'prompt', [body.system, body.prompt].filter(Boolean).join('\n\n'), { user, policy })
+  if (check?.action === 'reject') return jsonResponse(guardrailError(check), 400)
+
+  const id = newJobId()
+  const busy = await acquireSlots(env.JOBS, token, id, targets.length, inflightLimit(env))
+  if (busy) return busy
+
+  const job = {
+    id,
+    kind: 'fanout',
+    prompt: body.prompt,
+    system: body.system || '',
+    status: 'running',
+    error: '',
+    results: targets.map((t): FanoutResult => ({
+      router: t.router.id,
+      model: t.model,
+      status: 'running',
+      result: '',
+      error: '',
+      tokens_in: 0,
+      tokens_out: 0,
+      latency_ms: 0,
+    })),
+    created: new Date().toISOString(),
+    finished: '',
+    latency_ms: 0,
+    tags: labels.tags,
+    metadata: labels.metadata,
+    ...(check ? { guardrails: [check] } : {}),
+  }
+
+  const redaction = await getRedaction(env.JOBS, token)
+  await putJob(env.JOBS, token, job, redaction)
+  await indexJob(env.JOBS, token, id)
+  // Recent prompts feed autocomplete; redacted prompts stay out of it
+  if (redaction.prompts === 'none') locals.runtime.ctx.waitUntil(recordRecentPrompt(env.JOBS, token, body.prompt!))
+
+  // Run all targets concurrently; the grouped job finishes when the slowest does
+  const client = clientLabel(request)
+  const ctx = locals.runtime.ctx
+  ctx.waitUntil((async () => {
+    const start = Date.now()
+    const outs = await Promise.all(targets.map(t => complete(t, body.prompt!, body.system)))
+    outs.forEach(({ rate_limit: _rl, ...out }, i) => Object.assign(job.results[i], out))
+
+    job.latency_ms = Date.now() - start
+    job.finished = new Date().toISOString()
+    if (outs.some(o => o.status === 'done')) {
+      job.status = 'done'
+    } else {
+      job.status = 'error'
+      job.error = 'all targets failed'
+    }
+    await putJob(env.JOBS, token, job, redaction)
+    for (const [i, out] of outs.entries()) await recordCompletion(env.JOBS, token, targets[i], out, 'fanout', client)
+    await notifyJob(env.JOBS, token, job)
+  })().finally(() => releaseSlots(env.JOBS, token, id)))
+
+  return jsonResponse({
+    id,
+    kind: 'fanout',
+    targets: targets.map(t => ({ router: t.router.id, model: t.model })),
+    status: 'running',
+  })
+}
@@ -1,7 +1,53 @@
 import type { APIRoute } from 'astro'
 import { extractToken, resolveUser, jsonResponse, unauthorized } from '../../lib/auth'
+import { matchesLabels, getJob } from '../../lib/jobs'
+import type { Job } from '../../lib/jobs'
 
-export const GET: APIRoute = async ({ locals, request }) => {
+const DEFAULT_LIMIT = 50
+const MAX_LIMIT = 100
+/** Jobs read per request at most, so sparse filters can't blow the subrequest budget. */
+const MAX_SCAN = 200
+
+/** `since` / `until` in the same form as `created`, so string comparison orders by time. */
+function isoParam(params: URLSearchParams, key: string): string | null {
+  const value = params.get(key)
+  return value ? new Date(value).toISOString() : null
+}
+
+/**
+ * Where to resume after `cursor`, the last job ID of the previous page. Newer jobs are
+ * prepended to the index, so a position would shift; an ID that has since left the index
+ * resumes at the first older ID (job IDs are ULIDs and sort by creation time).
+ */
+function resumeAt(index: string[], cursor: string | null): number {
+  if (!cursor) return 0
+  const at = index.indexOf(cursor)
+  if (at !== -1) return at + 1
+  const older = index.findIndex(id => id < cursor)
+  return older === -1 ? index.length : older
+}
+
+function matches(job: Job, params: URLSearchParams): boolean {
+  const status = params.get('status')
+  const router = params.get('router')
+  const since = isoParam(params, 'since')
+  const until = isoParam(params, 'until')
+  if (status && job.status !== status) return false
+  if (router && job.router !== router && !(job as { results?: { router: string }[] }).results?.some(r => r.router === router)) return false
+  if (since && job.created < since) return false
+  if (until && job.created >= until) return false
+  return matchesLabels(job, params)
+}
+
+/**
+ * GET /api/jobs — newest jobs first, as a JSON array.
+ *
+ * Filters: `status`, `router`, `since` / `until` (ISO timestamps, compared to `created`),
+ * `tag` (repeatable, all must match) and `meta.key=value`. Page with `limit` (max 100) and
+ * `cursor` (the last job ID scanned); when more jobs may follow, the response carries
+ * `X-Next-Cursor` and a `Link: <...>; rel="next"` header.
+ */
+export const GET: APIRoute = async ({ locals, request, url }) => {
   const env = locals.runtime.env as Env
 
   const token = extractToken(request)
@@ -9,15 +55,37 @@ export const GET: APIRoute = async ({ locals, request }) => {
   const user = await resolveUser(token, env.JOBS)
   if (!user) return unauthorized()
 
+  const limit = Math.min(Math.max(Number(url.searchParams.get('limit')) || DEFAULT_LIMIT, 1), MAX_LIMIT)
+  for (const key of ['since', 'until']) {
+    const value = url.searchParams.get(key)
+    if (value && Number.isNaN(Date.parse(value))) return jsonResponse({ error: `${key} must be an ISO timestamp` }, 400)
+  }
+
   const indexRaw = await env.JOBS.get(`jobindex:${token}`)
   const index: string[] = indexRaw ? JSON.parse(indexRaw) : []
+  const cursor = resumeAt(index, url.searchParams.get('cursor'))
 
-  const jobs = await Promise.all(
-    index.slice(0, 50).map(async (id) => {
-      const raw = await env.JOBS.get(`job:${token}:${id}`)
-      return raw ? JSON.parse(raw) : null
-    })
-  )
+  // Scan the index from the cursor in batches until the page is full or the scan budget runs out
+  const jobs: Job[] = []
+  let pos = cursor
+  while (jobs.length < limit && pos < index.length && pos - cursor < MAX_SCAN) {
+    const batch = index.slice(pos, pos + Math.min(limit - jobs.length, MAX_SCAN - (pos - cursor)))
+    const found = await Promise.all(batch.map(id => getJob(env.JOBS, token, id)))
+    let taken = 0
+    for (const job of found) {
+      taken++
+      if (job && matches(job, url.searchParams)) jobs.push(job)
+      if (jobs.length === limit) break
+    }
+    pos += taken
+  }
 
-  return jsonResponse(jobs.filter(Boolean))
+  const headers: Record<string, string> = {}
+  if (pos < index.length) {
+    const next = new URL(url)
+    next.searchParams.set('cursor', index[pos - 1])
+    headers['X-Next-Cursor'] = index[pos - 1]
+    headers['Link'] = `<${next.pathname}${next.search}>; rel="next"`
+  }
+  return new Response(JSON.stringify(jobs), { headers: { 'Content-Type': 'application/json', ...headers } })
 }
@@ -1,21 +1,16 @@
 import type { APIRoute } from 'astro'
-import { extractToken, resolveUser, jsonResponse, unauthorized } from '../../lib/auth'
-
-function generateToken(): string {
-  const bytes = new Uint8Array(32)
-  crypto.getRandomValues(bytes)
-  return Array.from(bytes).map(b => b.toString(16).padStart(2, '0')).join('')
-}
-
-function previewKey(key: string): string {
-  if (key.length <= 8) return key.slice(0, 2) + '...' + key.slice(-2)
-  return key.slice(0, 4) + '...' + key.slice(-4)
-}
+import { extractToken, resolveUser, previewKey, generateToken, jsonResponse, unauthorized } from '../../lib/auth'
+import type { UserRecord } from '../../lib/auth'
+import { getRouter, missingSettings } from '../../lib/routers'
 
 export const POST: APIRoute = async ({ request, locals }) => {
   const env = locals.runtime.env as Env
 
-  let body: { openrouter_key?: string; keys?: Record<string, string> }
+  let body: {
+    openrouter_key?: string
+    keys?: Record<string, string>
+    settings?: Record<string, Record<string, string>>
+  }
   try {
     body = await request.json()
   } catch {
@@ -34,6 +29,16 @@ export const POST: APIRoute = async ({ request, locals }) => {
     if (Object.keys(keys).length === 0) {
       return jsonResponse({ error: 'at least one key required' }, 400)
     }
+
+    // Multi-field routers must arrive complete — never store a half-configured router
+    for (const routerId of Object.keys(keys)) {
+      const router = getRouter(routerId)
+      if (!router?.settings) continue
+      const missing = missingSettings(router, body.settings?.[routerId])
+      if (missing.length > 0) {
+        return jsonResponse({ error: `${router.name} requires settings.${routerId}: ${missing.join(', ')}` }, 400)
+      }
+    }
   } else if (body.openrouter_key) {
     // Old format: { openrouter_key: "sk-or-..." }
     const key = body.openrouter_key.trim()
@@ -55,7 +60,14 @@ export const POST: APIRoute = async ({ request, locals }) => {
   }
 
   const token = generateToken()
-  const record = { keys, created: new Date().toISOString() }
+  const record: UserRecord = { keys, created: new Date().toISOString() }
+  const settings: Record<string, Record<string, string>> = {}
+  for (const routerId of Object.keys(keys)) {
+    const fields = getRouter(routerId)?.settings
+    if (!fields) continue
+    settings[routerId] = Object.fromEntries(fields.map(f => [f.id, body.settings![routerId][f.id].trim()]))
+  }
+  if (Object.keys(settings).length > 0) record.settings = settings
 
   // Store user record (no expiry — persists until deleted)
   await env.JOBS.put(`user:${token}`, JSON.stringify(record))
@@ -76,7 +88,7 @@ export const GET: APIRoute = async ({ request, locals }) => {
     previews[routerId] = previewKey(apiKey)
   }
 
-  return jsonResponse({ keys: previews, created: user.created })
+  return jsonResponse({ keys: previews, settings: user.settings ?? {}, created: user.created })
 }
 
 export const DELETE: APIRoute = async ({ request, locals }) => {
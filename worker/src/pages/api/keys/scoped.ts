@@ -0,0 +1,57 @@
+import type { APIRoute } from 'astro'
+import { extractToken, resolveUser, jsonResponse, unauthorized } from '../../../lib/auth'
+import { ScopedTokenSchema, listScoped, mintScoped, revokeScoped, describeScoped } from '../../../lib/scoped'
+import { formatIssues } from '../../../lib/settings'
+
+/** Live scoped tokens (ids only; the secret is shown once, at mint time). */
+export const GET: APIRoute = async ({ request, locals }) => {
+  const env = locals.runtime.env as Env
+  const token = extractToken(request)
+  if (!token) return unauthorized()
+  const user = await resolveUser(token, env.JOBS)
+  if (!user) return unauthorized()
+
+  const tokens = await listScoped(env.JOBS, token)
+  return jsonResponse({ tokens: tokens.map(describeScoped) })
+}
+
+/**
+ * Mint a scoped token: `{ label, ttl_s? }` (default 1h, max 7 days). It works on /v1 only,
+ * and its usage shows up under `label` in /api/usage/by-client.
+ */
+export const POST: APIRoute = async ({ request, locals }) => {
+  const env = locals.runtime.env as Env
+  const token = extractToken(request)
+  if (!token) return unauthorized()
+  const user = await resolveUser(token, env.JOBS)
+  if (!user) return unauthorized()
+
+  let raw: unknown
+  try {
+    raw = await request.json()
+  } catch {
+    return jsonResponse({ error: 'invalid JSON' }, 400)
+  }
+
+  const parsed = ScopedTokenSchema.safeParse(raw)
+  if (!parsed.success) {
+    return jsonResponse({ error: formatIssues(parsed.error) }, 400)
+  }
+
+  const minted = await mintScoped(env.JOBS, token, parsed.data)
+  if ('error' in minted) return jsonResponse({ error: minted.error }, 409)
+  return jsonResponse({ token: minted.token, ...describeScoped(minted.record) })
+}
+
+/** Revoke a scoped token before it expires: `?id=chs_xxxxxxxx`. */
+export const DELETE: APIRoute = async ({ request, locals, url }) => {
+  const env = locals.runtime.env as Env
+  const token = extractToken(request)
+  if (!token) return unauthorized()
+  const user = await resolveUser(token, env.JOBS)
+  if (!user) return unauthorized()
+
+  const id = url.searchParams.get('id') ?? ''
+  if (!(await revokeScoped(env.JOBS, token, id))) return jsonResponse({ error: `unknown scoped token: ${id}` }, 404)
+  return jsonResponse({ revoked: id })
+}
@@ -1,38 +1,71 @@
 import type { APIRoute } from 'astro'
+import { extractToken, resolveUser, getUserKey, getUserSettings, jsonResponse } from '../../../lib/auth'
+import type { UserRecord } from '../../../lib/auth'
+import { routers, getRouter, missingSettings } from '../../../lib/routers'
+import type { RouterDef } from '../../../lib/routers'
+import { getModelPolicy } from '../../../lib/policy'
+import type { ModelPolicy } from '../../../lib/policy'
+import { scanFreeModels } from '../../../lib/free'
 
-interface OpenRouterModel {
-  id: string
-  name: string
-  context_length: number
-  top_provider?: { max_completion_tokens?: number }
-  created?: number
-}
+/**
+ * GET /api/models/free?router=openrouter|zen|groq|...|all
+ *
+ * Defaults to OpenRouter, which needs no key. Other routers are scanned with the caller's
+ * key, so `router=all` covers OpenRouter plus every router the caller has configured.
+ * Authenticated callers get results filtered by their model policy.
+ */
+export const GET: APIRoute = async ({ locals, request, url }) => {
+  let user: UserRecord | null = null
+  let policy: ModelPolicy | undefined
+  const token = extractToken(request)
+  if (token) {
+    const env = locals.runtime.env as Env
+    user = await resolveUser(token, env.JOBS)
+    if (user) policy = await getModelPolicy(env.JOBS, token)
+  }
 
-export const GET: APIRoute = async ({ locals }) => {
-  const resp = await fetch('https://openrouter.ai/api/v1/models')
-  if (!resp.ok) {
-    return new Response(JSON.stringify({ error: 'Failed to fetch models' }), { status: 502 })
+  const which = url.searchParams.get('router') || 'openrouter'
+  let targets: RouterDef[]
+  if (which === 'all') {
+    targets = routers.filter(r =>
+      r.id === 'openrouter' || (user?.keys[r.id] && missingSettings(r, user.settings?.[r.id]).length === 0))
+  } else {
+    const router = getRouter(which)
+    if (!router) return jsonResponse({ error: `Unknown router: ${which}` }, 400)
+    targets = [router]
   }
 
-  const { data } = await resp.json() as { data: OpenRouterModel[] }
+  const results = await Promise.allSettled(
+    targets.map(router => scanFreeModels({
+      router,
+      apiKey: user ? getUserKey(user, router.id) : null,
+      settings: user ? getUserSettings(user, router.id) : undefined,
+      policy,
+    })),
+  )
+
+  const errors: Record<string, string> = {}
+  const models = results.flatMap((r, i) => {
+    if (r.status === 'fulfilled') return r.value
+    errors[targets[i].id] = String(r.reason instanceof Error ? r.reason.message : r.reason)
+    return []
+  })
+  if (models.length === 0 && Object.keys(errors).length === targets.length) {
+    return jsonResponse({ error: 'Failed to fetch models', routers: errors }, 502)
+  }
 
-  const free = data
-    .filter(m => m.id.endsWith(':free'))
-    .filter(m => {
-      const name = m.name.toLowerCase()
-      const tiny = ['1b', '3b', '7b', '8b'].some(s => name.includes(s))
-      const big = ['70b', '80b', '180b'].some(s => name.includes(s))
-      return !tiny || big
-    })
+  const free = models
     .map(m => ({
-      id: m.id,
-      name: m.name,
-      context_length: m.context_length,
-      max_output: m.top_provider?.max_completion_tokens || 0,
+      ...m,
+      target: `${m.router}/${m.id}`,
+      ...(policy?.notes[m.id] ? { note: policy.notes[m.id] } : {}),
     }))
     .sort((a, b) => b.context_length - a.context_length)
 
-  return new Response(JSON.stringify({ count: free.length, models: free }), {
-    headers: { 'Content-Type': 'application/json', 'Cache-Control': 'public, max-age=900' },
+  return new Response(JSON.stringify({ count: free.length, models: free, errors }), {
+    headers: {
+      'Content-Type': 'application/json',
+      'Cache-Control': user ? 'private, max-age=60' : 'public, max-age=900',
+    },
   })
 }
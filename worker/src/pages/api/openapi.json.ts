@@ -0,0 +1,8 @@
+import type { APIRoute } from 'astro'
+import { buildSpec } from '../../lib/openapi'
+
+export const GET: APIRoute = async ({ url }) => {
+  return new Response(JSON.stringify(buildSpec(url.origin), null, 2), {
+    headers: { 'Content-Type': 'application/json', 'Cache-Control': 'public, max-age=300' },
+  })
+}
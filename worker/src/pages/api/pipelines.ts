@@ -0,0 +1,149 @@
+import type { APIRoute } from 'astro'
+import { extractToken, resolveUser, jsonResponse, unauthorized } from '../../lib/auth'
+import { resolveTarget, isTargetError, complete } from '../../lib/dispatch'
+import type { Target, Completion } from '../../lib/dispatch'
+import { newJobId, putJob, indexJob, parseJobLabels } from '../../lib/jobs'
+import { getModelPolicy } from '../../lib/policy'
+import { recordCompletion, clientLabel } from '../../lib/stats'
+import { notifyJob } from '../../lib/notify'
+import { getRedaction } from '../../lib/redact'
+import { acquireSlots, releaseSlots, inflightLimit } from '../../lib/inflight'
+import { getGuardrails, checkGuardrails, guardrailError } from '../../lib/guardrails'
+import { resolvePreset } from '../../lib/prompts'
+import { PipelineSchema, checkSteps, renderPrompt } from '../../lib/pipelines'
+import { formatIssues } from '../../lib/settings'
+
+interface StepResult extends Omit<Completion, 'status' | 'rate_limit'> {
+  name: string
+  router: string
+  model: string
+  preset?: string
+  prompt: string
+  status: string
+}
+
+/**
+ * POST /api/pipelines — run a multi-step chain as one background job (`kind: "pipeline"`).
+ * Steps run in order; the first failure stops the run. Poll with /api/result/:id.
+ */
+export const POST: APIRoute = async ({ request, locals }) => {
+  const env = locals.runtime.env as Env
+
+  const token = extractToken(request)
+  if (!token) return unauthorized()
+  const user = await resolveUser(token, env.JOBS)
+  if (!user) return unauthorized()
+
+  let raw: unknown
+  try {
+    raw = await request.json()
+  } catch {
+    return jsonResponse({ error: 'invalid JSON' }, 400)
+  }
+  const parsed = PipelineSchema.safeParse(raw)
+  if (!parsed.success) {
+    return jsonResponse({ error: formatIssues(parsed.error) }, 400)
+  }
+  const body = parsed.data
+  const labels = parseJobLabels(body)
+  if ('error' in labels) return jsonResponse({ error: labels.error }, 400)
+  const steps = checkSteps(body.steps)
+  if ('error' in steps) return jsonResponse({ error: steps.error }, 400)
+
+  // Resolve every step's target and preset up front so a bad step fails the whole request
+  const policy = await getModelPolicy(env.JOBS, token)
+  const targets: Target[] = []
+  const systems: { text: string; preset?: string }[] = []
+  for (const step of steps) {
+    const target = await resolveTarget(user, { router: step.router, model: step.model }, policy)
+    if (isTargetError(target)) return jsonResponse({ error: `step ${step.name}: ${target.error}` }, target.status)
+    targets.push(target)
+
+    const preset = step.preset === undefined ? undefined : await resolvePreset(env.JOBS, token, step.preset)
+    if (preset && 'error' in preset) return jsonResponse({ error: `step ${step.name}: ${preset.error}` }, 400)
+    systems.push({ text: [preset?.text, step.system].filter(Boolean).join('\n\n'), preset: preset?.ref })
+  }
+
+  // Guardrails check the input and every step's prompt template and system text
+  const guardrailText = [body.input, ...steps.map((s, i) => `${systems[i].text}\n\n${s.prompt}`)].filter(Boolean).join('\n\n')
+  const check = await checkGuardrails(await getGuardrails(env.JOBS, token), 'prompt', guardrailText, { user, policy })
+  if (check?.action === 'reject') return jsonResponse(guardrailError(check), 400)
+
+  // Steps run one at a time, so a pipeline takes one slot
+  const id = newJobId()
+  const busy = await acquireSlots(env.JOBS, token, id, 1, inflightLimit(env))
+  if (busy) return busy
+
+  const job = {
+    id,
+    kind: 'pipeline',
+    input: body.input,
+    status: 'running',
+    result: '',
+    error: '',
+    steps: steps.map((s, i): StepResult => ({
+      name: s.name,
+      router: targets[i].router.id,
+      model: targets[i].model,
+      ...(systems[i].preset ? { preset: systems[i].preset } : {}),
+      prompt: '',
+      status: 'pending',
+      result: '',
+      error: '',
+      tokens_in: 0,
+      tokens_out: 0,
+      latency_ms: 0,
+    })),
+    created: new Date().toISOString(),
+    finished: '',
+    latency_ms: 0,
+    tags: labels.tags,
+    metadata: labels.metadata,
+    ...(check ? { guardrails: [check] } : {}),
+  }
+
+  const redaction = await getRedaction(env.JOBS, token)
+  await putJob(env.JOBS, token, job, redaction)
+  await indexJob(env.JOBS, token, id)
+
+  // Each step is stored as it finishes, so polling shows progress
+  const client = clientLabel(request)
+  const ctx = locals.runtime.ctx
+  ctx.waitUntil((async () => {
+    const start = Date.now()
+    const results: Record<string, string> = {}
+    let prev = body.input
+    for (const [i, step] of steps.entries()) {
+      const entry = job.steps[i]
+      entry.prompt = renderPrompt(step.prompt, body.input, results, prev)
+      entry.status = 'running'
+      await putJob(env.JOBS, token, job, redaction)
+
+      const { rate_limit: _rl, ...out } = await complete(targets[i], entry.prompt, systems[i].text || undefined)
+      Object.assign(entry, out)
+      await recordCompletion(env.JOBS, token, targets[i], out, 'pipeline', client)
+      if (out.status !== 'done') {
+        job.status = 'error'
+        job.error = `step ${step.name} failed: ${out.error}`
+        break
+      }
+      results[step.name] = prev = out.result
+    }
+
+    if (job.status === 'running') {
+      job.status = 'done'
+      job.result = prev
+    }
+    job.latency_ms = Date.now() - start
+    job.finished = new Date().toISOString()
+    await putJob(env.JOBS, token, job, redaction)
+    await notifyJob(env.JOBS, token, job)
+  })().finally(() => releaseSlots(env.JOBS, token, id)))
+
+  return jsonResponse({
+    id,
+    kind: 'pipeline',
+    steps: job.steps.map(s => ({ name: s.name, router: s.router, model: s.model })),
+    status: 'running',
+  })
+}
@@ -0,0 +1,27 @@
+import type { APIRoute } from 'astro'
+import { extractToken, resolveUser, jsonResponse, unauthorized } from '../../../lib/auth'
+import { getRecentPrompts, clearRecentPrompts } from '../../../lib/prompts'
+
+/** GET /api/prompts/recent?q= — deduplicated recent prompts, newest first; `q` filters by substring. */
+export const GET: APIRoute = async ({ request, locals, url }) => {
+  const env = locals.runtime.env as Env
+  const token = extractToken(request)
+  if (!token) return unauthorized()
+  const user = await resolveUser(token, env.JOBS)
+  if (!user) return unauthorized()
+
+  const q = url.searchParams.get('q')?.toLowerCase()
+  const recent = await getRecentPrompts(env.JOBS, token)
+  return jsonResponse({ prompts: q ? recent.filter(r => r.prompt.toLowerCase().includes(q)) : recent })
+}
+
+export const DELETE: APIRoute = async ({ request, locals }) => {
+  const env = locals.runtime.env as Env
+  const token = extractToken(request)
+  if (!token) return unauthorized()
+  const user = await resolveUser(token, env.JOBS)
+  if (!user) return unauthorized()
+
+  await clearRecentPrompts(env.JOBS, token)
+  return jsonResponse({ prompts: [] })
+}
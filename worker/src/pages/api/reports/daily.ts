@@ -0,0 +1,59 @@
+import type { APIRoute } from 'astro'
+import { extractToken, resolveUser, jsonResponse, unauthorized } from '../../../lib/auth'
+import { getModelPolicy } from '../../../lib/policy'
+import { getDailyReport, formatReport } from '../../../lib/reports'
+import { notify } from '../../../lib/notify'
+import { STATS_TTL } from '../../../lib/stats'
+
+/** `?date=YYYY-MM-DD` (UTC, default today), within the 90 days stats are kept. */
+function parseDate(value: string | null): string | null {
+  const today = new Date().toISOString().slice(0, 10)
+  if (!value) return today
+  if (!/^\d{4}-\d{2}-\d{2}$/.test(value) || Number.isNaN(Date.parse(value))) return null
+  const age = Date.parse(today) - Date.parse(value)
+  return age >= 0 && age < STATS_TTL * 1000 ? value : null
+}
+
+/** GET /api/reports/daily?date=&refresh=1 — compile (or read the cached) daily report. */
+export const GET: APIRoute = async ({ request, locals, url }) => {
+  const env = locals.runtime.env as Env
+  const token = extractToken(request)
+  if (!token) return unauthorized()
+  const user = await resolveUser(token, env.JOBS)
+  if (!user) return unauthorized()
+
+  const date = parseDate(url.searchParams.get('date'))
+  if (!date) return jsonResponse({ error: 'date must be YYYY-MM-DD within the last 90 days' }, 400)
+
+  const policy = await getModelPolicy(env.JOBS, token)
+  const report = await getDailyReport({
+    kv: env.JOBS, token, user, policy, date, refresh: url.searchParams.get('refresh') === '1',
+  })
+  return jsonResponse(report)
+}
+
+/**
+ * POST /api/reports/daily `{ date? }` — compile the report and send it to notification
+ * channels subscribed to `report.daily`. Point a cron job at this for a scheduled digest.
+ */
+export const POST: APIRoute = async ({ request, locals }) => {
+  const env = locals.runtime.env as Env
+  const token = extractToken(request)
+  if (!token) return unauthorized()
+  const user = await resolveUser(token, env.JOBS)
+  if (!user) return unauthorized()
+
+  let body: { date?: string } = {}
+  try {
+    body = await request.json()
+  } catch {
+    // empty body is fine
+  }
+  const date = parseDate(body.date ?? null)
+  if (!date) return jsonResponse({ error: 'date must be YYYY-MM-DD within the last 90 days' }, 400)
+
+  const policy = await getModelPolicy(env.JOBS, token)
+  const report = await getDailyReport({ kv: env.JOBS, token, user, policy, date, refresh: true })
+  await notify(env.JOBS, token, 'report.daily', formatReport(report), { report })
+  return jsonResponse(report)
+}
@@ -0,0 +1,70 @@
+import type { APIRoute } from 'astro'
+import { extractToken, resolveUser, generateToken, saveUser, jsonResponse, unauthorized } from '../../lib/auth'
+import type { UserRecord } from '../../lib/auth'
+import { setupChecks } from '../../lib/setup'
+import { importBundle, BUNDLE_VERSION } from '../../lib/bundle'
+
+/**
+ * GET /api/setup — what's still missing. Works without a token (deployment checks only);
+ * with one it also checks the user's routers. `complete` is true when every required check passes.
+ */
+export const GET: APIRoute = async ({ request, locals }) => {
+  const env = locals.runtime.env as Env
+  const token = extractToken(request)
+  const user = token ? await resolveUser(token, env.JOBS) : null
+  if (token && !user) return unauthorized()
+
+  const checks = await setupChecks(env, token && user ? { token, user } : null)
+  return jsonResponse({ complete: checks.every(c => c.ok || !c.required), checks })
+}
+
+/**
+ * POST /api/setup — configure everything in one call. The body is a config bundle
+ * (see /api/config/bundle); `chomp_bundle` may be omitted. Without a token, `keys` (or
+ * `encrypted_keys`) is required and a new token is created and returned.
+ */
+export const POST: APIRoute = async ({ request, locals }) => {
+  const env = locals.runtime.env as Env
+
+  let body: Record<string, unknown>
+  try {
+    body = await request.json()
+  } catch {
+    return jsonResponse({ error: 'invalid JSON' }, 400)
+  }
+  if (typeof body !== 'object' || body === null || Array.isArray(body)) {
+    return jsonResponse({ error: 'body must be an object' }, 400)
+  }
+
+  let token = extractToken(request)
+  let user: UserRecord | null = null
+  let created = false
+  if (token) {
+    user = await resolveUser(token, env.JOBS)
+    if (!user) return unauthorized()
+  } else {
+    const keys = body.keys as Record<string, unknown> | undefined
+    const hasKeys = (keys && typeof keys === 'object' && Object.keys(keys).length > 0) || body.encrypted_keys
+    if (!hasKeys) {
+      return jsonResponse({ error: 'keys required to create a token (or send Authorization to update one)' }, 400)
+    }
+    token = generateToken()
+    user = { keys: {}, created: new Date().toISOString() }
+    created = true
+  }
+
+  const passphrase = request.headers.get('X-Bundle-Passphrase') || undefined
+  const result = await importBundle(env.JOBS, token, user, { chomp_bundle: BUNDLE_VERSION, ...body }, passphrase)
+  if ('error' in result) return jsonResponse({ error: result.error }, 400)
+  // importBundle only writes the user record when keys are present; a new token always needs one
+  if (created && !result.imported.includes('keys')) await saveUser(token, result.user, env.JOBS)
+
+  // Check the record just written rather than re-reading it (KV reads can lag the write)
+  const checks = await setupChecks(env, { token, user: result.user })
+  return jsonResponse({
+    ...(created ? { token } : {}),
+    imported: result.imported,
+    complete: checks.every(c => c.ok || !c.required),
+    checks,
+  })
+}
@@ -0,0 +1,41 @@
+import type { APIRoute } from 'astro'
+import { extractToken, resolveUser, jsonResponse, unauthorized } from '../../lib/auth'
+import { getStats, summarize } from '../../lib/stats'
+import type { Counters } from '../../lib/stats'
+import { inflightSlots, inflightLimit } from '../../lib/inflight'
+import { getLatencySamples, latencyByRouter, latencyByModel } from '../../lib/latency'
+
+const MAX_DAYS = 90
+
+/**
+ * GET /api/stats?days=7 — daily usage buckets plus totals per router and per source, and the
+ * background jobs currently in flight against the per-user limit. `latency` is the rolling
+ * p50/p95 of recent successful calls per router and per router/model.
+ */
+export const GET: APIRoute = async ({ request, locals, url }) => {
+  const env = locals.runtime.env as Env
+  const token = extractToken(request)
+  if (!token) return unauthorized()
+  const user = await resolveUser(token, env.JOBS)
+  if (!user) return unauthorized()
+
+  const days = Math.min(Math.max(Number(url.searchParams.get('days')) || 7, 1), MAX_DAYS)
+  const [buckets, samples] = await Promise.all([getStats(env.JOBS, token, days), getLatencySamples(env.JOBS, token)])
+
+  const group = (pick: (b: (typeof buckets)[number]) => Record<string, Counters>) => {
+    const grouped: Record<string, Counters[]> = {}
+    for (const b of buckets) {
+      for (const [k, c] of Object.entries(pick(b))) (grouped[k] ??= []).push(c)
+    }
+    return Object.fromEntries(Object.entries(grouped).map(([k, cs]) => [k, summarize(cs)]))
+  }
+
+  return jsonResponse({
+    days: buckets.map(({ by_router, by_source, by_client: _clients, ...b }) => ({ ...b, ...summarize([b]), by_router, by_source })),
+    totals: summarize(buckets),
+    by_router: group(b => b.by_router),
+    by_source: group(b => b.by_source),
+    inflight: { ...(await inflightSlots(env.JOBS, token)), limit: inflightLimit(env) },
+    latency: { by_router: latencyByRouter(samples), by_model: latencyByModel(samples) },
+  })
+}
@@ -0,0 +1,41 @@
+import type { APIRoute } from 'astro'
+import { extractToken, resolveUser, jsonResponse, unauthorized } from '../../../lib/auth'
+import { getStats, summarize } from '../../../lib/stats'
+import type { Counters } from '../../../lib/stats'
+
+const MAX_DAYS = 90
+
+/**
+ * GET /api/usage/by-client?days=7 — usage per `X-Chomp-Client` label, with a per-router
+ * breakdown for each client. Requests without a label are counted as "unlabeled".
+ */
+export const GET: APIRoute = async ({ request, locals, url }) => {
+  const env = locals.runtime.env as Env
+  const token = extractToken(request)
+  if (!token) return unauthorized()
+  const user = await resolveUser(token, env.JOBS)
+  if (!user) return unauthorized()
+
+  const days = Math.min(Math.max(Number(url.searchParams.get('days')) || 7, 1), MAX_DAYS)
+  const buckets = await getStats(env.JOBS, token, days)
+
+  const clients: Record<string, { totals: Counters[]; by_router: Record<string, Counters[]> }> = {}
+  for (const b of buckets) {
+    for (const [name, c] of Object.entries(b.by_client ?? {})) {
+      const entry = (clients[name] ??= { totals: [], by_router: {} })
+      entry.totals.push(c)
+      for (const [router, rc] of Object.entries(c.by_router)) (entry.by_router[router] ??= []).push(rc)
+    }
+  }
+
+  return jsonResponse({
+    days,
+    clients: Object.entries(clients)
+      .map(([name, c]) => ({
+        client: name,
+        ...summarize(c.totals),
+        by_router: Object.fromEntries(Object.entries(c.by_router).map(([r, cs]) => [r, summarize(cs)])),
+      }))
+      .sort((a, b) => (b.tokens_in + b.tokens_out) - (a.tokens_in + a.tokens_out)),
+  })
+}
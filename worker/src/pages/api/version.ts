@@ -0,0 +1,10 @@
+import type { APIRoute } from 'astro'
+import { jsonResponse } from '../../lib/auth'
+import { build, checkForUpdate } from '../../lib/version'
+
+/** GET /api/version — build version, commit and time, plus the latest release unless UPDATE_CHECK="false". */
+export const GET: APIRoute = async ({ locals }) => {
+  const env = locals.runtime.env as Env
+  const update = await checkForUpdate(env)
+  return jsonResponse({ ...build, ...(update ? { update } : {}) })
+}
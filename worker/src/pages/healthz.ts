@@ -0,0 +1,5 @@
+import type { APIRoute } from 'astro'
+import { probeResponse } from '../lib/health'
+
+/** Same as /livez; kept under the name most uptime monitors expect. */
+export const GET: APIRoute = () => probeResponse({ status: 'ok' })
@@ -0,0 +1,5 @@
+import type { APIRoute } from 'astro'
+import { probeResponse } from '../lib/health'
+
+/** Liveness: the Worker is running. Never touches storage. */
+export const GET: APIRoute = () => probeResponse({ status: 'ok' })
@@ -2,6 +2,7 @@ import type { APIRoute } from "astro"
 import { WebStandardStreamableHTTPServerTransport } from "@modelcontextprotocol/sdk/server/webStandardStreamableHttp.js"
 import { createMcpServer } from "../mcp/server.js"
 import { extractToken } from "../lib/auth.js"
+import { inflightLimit } from "../lib/inflight.js"
 
 // ---------------------------------------------------------------------------
 // Handler
@@ -18,7 +19,7 @@ async function handleMcp(request: Request, locals: App.Locals): Promise<Response
 
   const env = locals.runtime.env as Env
   const ctx = locals.runtime.ctx
-  const server = createMcpServer({ token, kv: env.JOBS, ctx })
+  const server = createMcpServer({ token, kv: env.JOBS, ctx, maxInflight: inflightLimit(env) })
 
   const transport = new WebStandardStreamableHTTPServerTransport({
     sessionIdGenerator: undefined, // stateless — CF Workers are request-scoped
@@ -0,0 +1,61 @@
+import type { APIRoute } from 'astro'
+import { extractToken, resolveUser, getUserKey, getUserSettings, jsonResponse, unauthorized } from '../../../lib/auth'
+import { getRouter, resolveBaseUrl, isProxyAllowed } from '../../../lib/routers'
+
+/**
+ * Read-through proxy to provider-native endpoints: /proxy/{router}/{path} is forwarded to
+ * {baseUrl}/{path} with the user's stored key injected. Only allowlisted paths go through
+ * (see DEFAULT_PROXY_PATHS and RouterDef.proxyPaths).
+ */
+const handle: APIRoute = async ({ params, request, locals, url }) => {
+  const env = locals.runtime.env as Env
+  const token = extractToken(request)
+  if (!token) return unauthorized()
+  const user = await resolveUser(token, env.JOBS)
+  if (!user) return unauthorized()
+
+  const router = getRouter(params.router ?? '')
+  if (!router) return jsonResponse({ error: `Unknown router: ${params.router}` }, 404)
+
+  const path = (params.path ?? '').replace(/^\/+|\/+$/g, '')
+  if (path.split('/').some(s => s === '..' || s === '.')) {
+    return jsonResponse({ error: 'invalid path' }, 400)
+  }
+  if (!isProxyAllowed(router, request.method, path)) {
+    return jsonResponse({ error: `${request.method} ${path} is not proxied for ${router.id}` }, 403)
+  }
+
+  const apiKey = getUserKey(user, router.id)
+  if (!apiKey) return jsonResponse({ error: `No ${router.name} key configured` }, 400)
+
+  const headers: Record<string, string> = {
+    Authorization: `Bearer ${apiKey}`,
+    ...router.headers,
+  }
+  const contentType = request.headers.get('Content-Type')
+  if (contentType) headers['Content-Type'] = contentType
+
+  // params.path arrives decoded; re-encode each segment so `%2F`, `?` or `#` can't reshape the upstream URL
+  const upstreamPath = path.split('/').map(encodeURIComponent).join('/')
+  const start = Date.now()
+  const upstream = await fetch(`${resolveBaseUrl(router, getUserSettings(user, router.id))}/${upstreamPath}${url.search}`, {
+    method: request.method,
+    headers,
+    body: request.method === 'GET' || request.method === 'HEAD' ? undefined : await request.arrayBuffer(),
+  })
+  // Proxied calls aren't in stats; this line (Workers Logs) is their record
+  console.log(`[proxy] ${router.id} ${request.method} /${path} → ${upstream.status} (${Date.now() - start}ms)`)
+
+  const out = new Headers()
+  for (const h of ['Content-Type', 'Cache-Control', 'Retry-After']) {
+    const v = upstream.headers.get(h)
+    if (v) out.set(h, v)
+  }
+  upstream.headers.forEach((v, k) => {
+    if (k.toLowerCase().startsWith('x-ratelimit-')) out.set(k, v)
+  })
+  return new Response(upstream.body, { status: upstream.status, headers: out })
+}
+
+export const GET = handle
+export const POST = handle
@@ -0,0 +1,9 @@
+import type { APIRoute } from 'astro'
+import { readiness, probeResponse } from '../lib/health'
+
+/** Readiness: 200 when storage and the router table are usable, 503 otherwise. */
+export const GET: APIRoute = async ({ locals }) => {
+  const env = locals.runtime.env as Env
+  const result = await readiness(env)
+  return probeResponse({ status: result.ready ? 'ok' : 'unavailable', ...result }, result.ready ? 200 : 503)
+}
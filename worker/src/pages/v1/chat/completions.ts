@@ -2,145 +2,273 @@ import type { APIRoute } from 'astro'
 import {
   extractToken,
   resolveUser,
-  getUserKey,
-  getFirstAvailableRouter,
   unauthorized,
   jsonResponse,
 } from '../../../lib/auth'
-import {
-  routers,
-  getRouter,
-  resolveRouterAndModel,
-  callRouter,
-} from '../../../lib/routers'
-
-const CORS_HEADERS: Record<string, string> = {
-  'Access-Control-Allow-Origin': '*',
-  'Access-Control-Allow-Methods': 'POST, OPTIONS',
-  'Access-Control-Allow-Headers': 'Content-Type, Authorization',
-}
-
-function corsJson(data: unknown, status = 200): Response {
-  const res = jsonResponse(data, status)
-  for (const [k, v] of Object.entries(CORS_HEADERS)) {
-    res.headers.set(k, v)
-  }
-  return res
-}
+import { callRouter, pickParams, DEFAULT_TIMEOUT_MS, MAX_TIMEOUT_MS } from '../../../lib/routers'
+import { resolveTarget, isTargetError } from '../../../lib/dispatch'
+import { getModelPolicy } from '../../../lib/policy'
+import { findModelInfo } from '../../../lib/models'
+import { estimateMessageTokens } from '../../../lib/context'
+import { recordCompletion, clientLabel } from '../../../lib/stats'
+import type { RateLimit } from '../../../lib/ratelimit'
+import { isRateLimited, retryDelayMs, maxWaitMs, sleep } from '../../../lib/ratelimit'
+import { routerPreference, markRouterUsed } from '../../../lib/balance'
+import { enforceFormat } from '../../../lib/structured'
+import { backoffMs, MAX_RETRIES } from '../../../lib/errors'
+import { resolvePreset } from '../../../lib/prompts'
+import { resolveScoped, isScopedToken } from '../../../lib/scoped'
+import { getGuardrails, checkGuardrails, guardrailError } from '../../../lib/guardrails'
+import type { GuardrailResult } from '../../../lib/guardrails'
+import { getTransforms, applyTransforms } from '../../../lib/transforms'
+import type { ResponseFormat } from '../../../lib/structured'
 
-export const OPTIONS: APIRoute = async () => {
-  return new Response(null, { status: 204, headers: CORS_HEADERS })
+/** HTTP status for a failed upstream call, by error class. */
+function errorStatus(cls: string | undefined): number {
+  if (cls === 'rate_limit') return 429
+  if (cls === 'bad_request' || cls === 'content_filter') return 400
+  if (cls === 'timeout') return 504
+  return 502
 }
 
 export const POST: APIRoute = async ({ request, locals }) => {
   try {
-    // 1. Auth
-    const token = extractToken(request)
-    if (!token) return unauthorized()
+    // 1. Auth (a scoped token acts as its parent, labelled as its own client)
+    const bearer = extractToken(request)
+    if (!bearer) return unauthorized()
 
-    const kv = locals.runtime.env.JOBS
+    const env = locals.runtime.env as Env
+    const kv = env.JOBS
+    const scoped = await resolveScoped(bearer, kv)
+    if (isScopedToken(bearer) && !scoped) return unauthorized()
+    const token = scoped?.parent ?? bearer
     const user = await resolveUser(token, kv)
     if (!user) return unauthorized()
+    const client = scoped?.label ?? clientLabel(request)
 
     // 2. Parse body
     interface ChatCompletionRequest {
       model: string
       messages: Array<{ role: string; content: string }>
-      temperature?: number
-      max_tokens?: number
       router?: string
+      preset?: string // chomp extension: named system prompt, `name` or `name@N`
+      [param: string]: unknown // standard OpenAI parameters, forwarded via pickParams
     }
 
     let body: ChatCompletionRequest
     try {
       body = await request.json()
     } catch {
-      return corsJson({ error: { message: 'invalid JSON body', type: 'invalid_request_error' } }, 400)
+      return jsonResponse({ error: { message: 'invalid JSON body', type: 'invalid_request_error' } }, 400)
     }
 
     if (!body.messages || !Array.isArray(body.messages) || body.messages.length === 0) {
-      return corsJson(
+      return jsonResponse(
         { error: { message: 'messages array is required and must not be empty', type: 'invalid_request_error' } },
         400,
       )
     }
 
-    // 3. Resolve router
-    let routerId: string | undefined = body.router
-    let model: string = body.model ?? ''
-
-    if (!routerId) {
-      const resolved = resolveRouterAndModel(model)
-      routerId = resolved.router
-      model = resolved.model
-    }
-
-    if (!routerId) {
-      routerId = getFirstAvailableRouter(user, routers.map((r) => r.id)) ?? undefined
+    // 2b. Preset system prompt goes before the caller's messages
+    let presetRef: string | undefined
+    if (body.preset !== undefined) {
+      const preset = await resolvePreset(kv, token, body.preset)
+      if ('error' in preset) {
+        return jsonResponse({ error: { message: preset.error, type: 'invalid_request_error' } }, 400)
+      }
+      body.messages = [{ role: 'system', content: preset.text }, ...body.messages]
+      presetRef = preset.ref
     }
 
-    if (!routerId) {
-      return corsJson(
-        { error: { message: 'unable to resolve a router — no keys configured', type: 'invalid_request_error' } },
-        400,
+    // Caller-supplied upstream key (opt-in per deployment): bills the caller's provider account.
+    // Resolution only sends it to the router the caller named.
+    const providerKey = request.headers.get('X-Provider-Key')?.trim() || undefined
+    if (providerKey && env.ALLOW_PROVIDER_KEYS !== 'true') {
+      return jsonResponse(
+        { error: { message: 'X-Provider-Key is not enabled on this server', type: 'permission_error' } },
+        403,
       )
     }
 
-    const routerDef = getRouter(routerId)
-    if (!routerDef) {
-      return corsJson(
-        { error: { message: `unknown router: ${routerId}`, type: 'invalid_request_error' } },
-        400,
-      )
+    // 3–4. Resolve router and model ("auto" picks a model whose context fits the prompt)
+    const policy = await getModelPolicy(kv, token)
+    const promptTokens = estimateMessageTokens(body.messages)
+    const prefer = body.router || providerKey ? undefined : await routerPreference(kv, token, user)
+    const target = await resolveTarget(user, { router: body.router, model: body.model, promptTokens, prefer, apiKey: providerKey }, policy)
+    if (isTargetError(target)) {
+      return jsonResponse({ error: { message: target.error, type: 'invalid_request_error' } }, target.status)
     }
 
-    // 4. Resolve model
-    if (!model || model === 'auto') {
-      model = routerDef.defaultModel
+    // Guardrails: reject, or flag in the `chomp` block
+    const guardrails = await getGuardrails(kv, token)
+    const flagged: GuardrailResult[] = []
+    const promptText = body.messages.map(m => typeof m.content === 'string' ? m.content : JSON.stringify(m.content)).join('\n\n')
+    const promptCheck = await checkGuardrails(guardrails, 'prompt', promptText, { user, policy })
+    if (promptCheck?.action === 'reject') {
+      const { error, guardrails: detail } = guardrailError(promptCheck)
+      return jsonResponse({ error: { message: error, type: 'invalid_request_error', code: 'guardrail', guardrails: detail } }, 400)
     }
+    if (promptCheck) flagged.push(promptCheck)
+
+    const { router: routerDef, model, apiKey, settings } = target
+    const routerId = routerDef.id
 
     // 5. Get API key
-    const apiKey = getUserKey(user, routerId)
     if (!apiKey) {
-      return corsJson(
+      return jsonResponse(
         { error: { message: `no key for router ${routerId}`, type: 'authentication_error' } },
         401,
       )
     }
 
-    // 6. Call upstream with 120s timeout
-    const controller = new AbortController()
-    const timeout = setTimeout(() => controller.abort(), 120_000)
+    // 6. Reject prompts that can't fit the model's context window (when the router says)
+    const info = await findModelInfo({ router: routerDef, model, apiKey, settings })
+    if (info?.context_length && promptTokens > info.context_length) {
+      return jsonResponse(
+        {
+          error: {
+            message: `prompt is ~${promptTokens} tokens but ${routerId}/${model} has a ${info.context_length}-token context window`,
+            type: 'invalid_request_error',
+            code: 'context_length_exceeded',
+          },
+        },
+        400,
+      )
+    }
+
+    // 7. Call upstream with the router's timeout (X-Chomp-Timeout seconds overrides, up to 300s).
+    //    Transient upstream errors (5xx, gateway) are retried with jittered backoff.
+    //    Rate-limited calls are parked and retried while the caller's X-Max-Wait allows,
+    //    then answered with 503 + Retry-After.
+    const requestedTimeout = Number(request.headers.get('X-Chomp-Timeout')) * 1000
+    const timeoutMs = requestedTimeout > 0
+      ? Math.min(requestedTimeout, MAX_TIMEOUT_MS)
+      : routerDef.timeoutMs ?? DEFAULT_TIMEOUT_MS
+    const params = pickParams(body)
+    const maxWait = maxWaitMs(request, env.QUEUE_MAX_WAIT)
     const start = Date.now()
+    let waitedMs = 0
 
     let result
-    try {
-      result = await callRouter({
-        router: routerDef,
-        apiKey,
-        model,
-        messages: body.messages,
-        signal: controller.signal,
-      })
-    } catch (err: unknown) {
+    let rateLimit: RateLimit | undefined
+    for (let attempt = 0; ; attempt++) {
+      const controller = new AbortController()
+      const timeout = setTimeout(() => controller.abort(), timeoutMs)
+      try {
+        result = await callRouter({
+          router: routerDef,
+          apiKey,
+          model,
+          messages: body.messages,
+          params,
+          settings,
+          signal: controller.signal,
+          onRateLimit: rl => { rateLimit = rl },
+        })
+      } catch (err: unknown) {
+        clearTimeout(timeout)
+        if (err instanceof DOMException && err.name === 'AbortError') {
+          return jsonResponse({ error: { message: `upstream timeout after ${timeoutMs / 1000}s`, type: 'timeout' } }, 504)
+        }
+        throw err
+      }
       clearTimeout(timeout)
-      if (err instanceof DOMException && err.name === 'AbortError') {
-        return corsJson({ error: { message: 'upstream timeout', type: 'timeout' } }, 504)
+
+      if (result.error?.class === 'transient' && attempt < MAX_RETRIES) {
+        await sleep(backoffMs(attempt))
+        continue
       }
-      throw err
+      if (maxWait === 0 || !isRateLimited(result)) break
+      const delayMs = retryDelayMs(rateLimit)
+      if (waitedMs + delayMs > maxWait) {
+        const retryAfter = Math.ceil(delayMs / 1000)
+        return new Response(JSON.stringify({
+          error: {
+            message: `${routerId} is rate-limited; retry in ~${retryAfter}s (waited ${Math.round(waitedMs / 1000)}s of X-Max-Wait)`,
+            type: 'rate_limit_error',
+            code: 'queue_timeout',
+          },
+        }), { status: 503, headers: { 'Content-Type': 'application/json', 'Retry-After': String(retryAfter) } })
+      }
+      await sleep(delayMs)
+      waitedMs += delayMs
+    }
+
+    // 7b. Structured output: check JSON replies locally and repair (or emulate
+    //     response_format on routers that reject it). Follow-up calls skip the queue.
+    let structured: { repairs: number; upstream: boolean; problem: string | null } | undefined
+    let usage = { prompt_tokens: result.usage?.prompt_tokens ?? 0, completion_tokens: result.usage?.completion_tokens ?? 0 }
+    const format = params.response_format as ResponseFormat | undefined
+    if (format && format.type !== 'text') {
+      const enforced = await enforceFormat({
+        format,
+        messages: body.messages,
+        first: result,
+        call: (messages, withFormat) => {
+          const { response_format: _rf, ...rest } = params
+          return callRouter({
+            router: routerDef,
+            apiKey,
+            model,
+            messages,
+            params: withFormat ? params : rest,
+            settings,
+            signal: AbortSignal.timeout(timeoutMs),
+          })
+        },
+      })
+      result = enforced.result
+      usage = enforced.usage
+      structured = { repairs: enforced.repairs, upstream: enforced.upstream, problem: enforced.problem }
     }
-    clearTimeout(timeout)
 
     const latencyMs = Date.now() - start
+    locals.runtime.ctx.waitUntil(recordCompletion(kv, token, target, {
+      status: result.error ? 'error' : 'done',
+      tokens_in: usage.prompt_tokens,
+      tokens_out: usage.completion_tokens,
+      latency_ms: latencyMs,
+      rate_limit: rateLimit,
+    }, 'v1', client))
+    locals.runtime.ctx.waitUntil(markRouterUsed(kv, token, routerId))
+
+    const reply = result.error ? null : result.choices?.[0]?.message?.content
+    const responseCheck = typeof reply === 'string' ? await checkGuardrails(guardrails, 'response', reply, { user, policy }) : null
+    if (responseCheck?.action === 'reject') {
+      const { error, guardrails: detail } = guardrailError(responseCheck)
+      return jsonResponse({ error: { message: error, type: 'invalid_request_error', code: 'guardrail', guardrails: detail } }, 400)
+    }
+    if (responseCheck) flagged.push(responseCheck)
+
+    // Post-processing rules for this client/route rewrite the reply text
+    let transforms: { applied: string[]; error?: string } | undefined
+    if (typeof reply === 'string') {
+      const transformed = await applyTransforms(await getTransforms(kv, token), reply, { route: 'v1', client })
+      if (transformed) {
+        const { text, ...report } = transformed
+        result.choices[0].message.content = text
+        transforms = report
+      }
+    }
 
-    // 7–8. Return response (pass through upstream errors as-is)
-    return corsJson({
+    // 8–9. Return response (upstream errors pass through with chomp's `class` and `status` added)
+    return jsonResponse({
       ...result,
-      chomp: { router: routerId, latency_ms: latencyMs },
-    }, result.error ? 502 : 200)
+      chomp: {
+        router: routerId,
+        latency_ms: latencyMs,
+        ...(waitedMs ? { queued_ms: waitedMs } : {}),
+        ...(structured ? { structured } : {}),
+        ...(providerKey ? { key: 'caller' } : {}),
+        ...(presetRef ? { preset: presetRef } : {}),
+        ...(flagged.length ? { guardrails: flagged } : {}),
+        ...(transforms ? { transforms } : {}),
+        ...(target.reason ? { selection: target.reason } : {}),
+      },
+    }, result.error ? errorStatus(result.error.class) : 200)
   } catch (err: unknown) {
     // 10. Unexpected errors
     const message = err instanceof Error ? err.message : 'internal server error'
-    return corsJson({ error: { message, type: 'internal_error' } }, 500)
+    return jsonResponse({ error: { message, type: 'internal_error' } }, 500)
   }
 }
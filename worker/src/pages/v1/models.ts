@@ -6,62 +6,23 @@ import {
   jsonResponse,
 } from "../../lib/auth";
 import { routers } from "../../lib/routers";
-import type { RouterDef } from "../../lib/routers";
-
-interface UpstreamModel {
-  id: string;
-  object: string;
-  created?: number;
-  owned_by?: string;
-}
+import { resolveScoped, isScopedToken } from "../../lib/scoped";
+import { getModelInfo } from "../../lib/models";
+import type { ModelInfo } from "../../lib/models";
 
 const CACHE_TTL = 15 * 60; // 15 minutes
 
-const CORS_HEADERS: Record<string, string> = {
-  "Access-Control-Allow-Origin": "*",
-  "Access-Control-Allow-Methods": "GET, OPTIONS",
-  "Access-Control-Allow-Headers": "Authorization, Content-Type",
-};
-
-function corsJson(data: unknown, status = 200): Response {
-  const res = jsonResponse(data, status);
-  for (const [k, v] of Object.entries(CORS_HEADERS)) {
-    res.headers.set(k, v);
-  }
-  return res;
-}
-
-async function fetchRouterModels(
-  router: RouterDef,
-  apiKey: string,
-): Promise<UpstreamModel[]> {
-  const headers: Record<string, string> = {
-    Authorization: `Bearer ${apiKey}`,
-    ...router.headers,
-  };
-
-  const res = await fetch(`${router.baseUrl}/models`, { headers });
-  if (!res.ok) {
-    console.warn(`[models] ${router.id}: HTTP ${res.status} ${res.statusText}`);
-    return [];
-  }
-
-  const body = (await res.json()) as { data?: UpstreamModel[] };
-  return body.data ?? [];
-}
-
-export const OPTIONS: APIRoute = async () => {
-  return new Response(null, { status: 204, headers: CORS_HEADERS });
-};
-
 export const GET: APIRoute = async ({ request, locals }) => {
   // 1. Auth
-  const token = extractToken(request);
-  if (!token) return corsJson({ error: "unauthorized" }, 401);
+  const bearer = extractToken(request);
+  if (!bearer) return unauthorized();
 
   const kv = (locals as any).runtime.env.JOBS as KVNamespace;
+  const scoped = await resolveScoped(bearer, kv);
+  if (isScopedToken(bearer) && !scoped) return unauthorized();
+  const token = scoped?.parent ?? bearer;
   const user = await resolveUser(token, kv);
-  if (!user) return corsJson({ error: "unauthorized" }, 401);
+  if (!user) return unauthorized();
 
   // 2. Cache check
   const cache = (caches as unknown as { default: Cache }).default;
@@ -76,24 +37,22 @@ export const GET: APIRoute = async ({ request, locals }) => {
   // 4. Fetch models from all routers in parallel
   const results = await Promise.allSettled(
     userRouters.map((router) =>
-      fetchRouterModels(router, user.keys[router.id]).then((models) =>
-        models.map((m) => ({
+      getModelInfo({
+        router,
+        apiKey: user.keys[router.id],
+        settings: user.settings?.[router.id],
+      }).then((models) =>
+        models.map(({ router: _router, ...m }) => ({
+          ...m,
           id: `${router.id}/${m.id}`,
           object: "model" as const,
-          created: m.created ?? 0,
-          owned_by: m.owned_by ?? router.id,
         })),
       ),
     ),
   );
 
   // 5. Aggregate successful results
-  const data: Array<{
-    id: string;
-    object: "model";
-    created: number;
-    owned_by: string;
-  }> = [];
+  const data: Array<Omit<ModelInfo, "router"> & { object: "model" }> = [];
 
   for (const result of results) {
     if (result.status === "fulfilled") {
@@ -104,7 +63,7 @@ export const GET: APIRoute = async ({ request, locals }) => {
   }
 
   // 6. Build OpenAI-format response
-  const response = corsJson({ object: "list", data });
+  const response = jsonResponse({ object: "list", data });
   response.headers.set("Cache-Control", `public, max-age=${CACHE_TTL}`);
 
   // 7. Store in cache (fire-and-forget)